@@ -4,7 +4,7 @@ go 1.16
 
 require (
 	github.com/iancoleman/strcase v0.1.3
-	github.com/klauspost/compress v1.11.12 // indirect
+	github.com/klauspost/compress v1.11.12
 	github.com/morkid/gocache v1.0.3
 	github.com/valyala/fasthttp v1.22.0
 	gorm.io/driver/sqlite v1.1.4
@@ -1,8 +1,18 @@
 package paginate
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"io"
 	"log"
@@ -10,12 +20,21 @@ import (
 	"net/http"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/iancoleman/strcase"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 	"github.com/morkid/gocache"
 	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
 
 	"github.com/valyala/fasthttp"
 )
@@ -23,7 +42,14 @@ import (
 // ResponseContext interface
 type ResponseContext interface {
 	Cache(string) ResponseContext
+	TTL(time.Duration) ResponseContext
+	StaleFor(time.Duration) ResponseContext
+	CacheKeyParts(...string) ResponseContext
 	Fields([]string) ResponseContext
+	SearchColumns([]string) ResponseContext
+	Preload(string, PreloadOptions) ResponseContext
+	Includes([]string) ResponseContext
+	Conditional(string) ResponseContext
 	Response(interface{}) Page
 }
 
@@ -34,7 +60,8 @@ type RequestContext interface {
 
 // Pagination gorm paginate struct
 type Pagination struct {
-	Config *Config
+	Config    *Config
+	cacheTags *cacheTagIndex
 }
 
 // With func
@@ -83,11 +110,19 @@ func (r reqContext) Request(req interface{}) ResponseContext {
 }
 
 type resContext struct {
-	Pagination  *Pagination
-	Statement   *gorm.DB
-	Request     interface{}
-	cachePrefix string
-	fieldList   []string
+	Pagination        *Pagination
+	Statement         *gorm.DB
+	Request           interface{}
+	cachePrefix       string
+	fieldList         []string
+	searchColumns     []string
+	preloads          map[string]PreloadOptions
+	includeList       []string
+	cacheKeyParts     []string
+	cacheTTL          time.Duration
+	cacheStale        time.Duration
+	conditional       bool
+	conditionalColumn string
 }
 
 func (r *resContext) Cache(prefix string) ResponseContext {
@@ -95,14 +130,79 @@ func (r *resContext) Cache(prefix string) ResponseContext {
 	return r
 }
 
+func (r *resContext) TTL(ttl time.Duration) ResponseContext {
+	r.cacheTTL = ttl
+	return r
+}
+
+func (r *resContext) StaleFor(stale time.Duration) ResponseContext {
+	r.cacheStale = stale
+	return r
+}
+
+func (r *resContext) CacheKeyParts(parts ...string) ResponseContext {
+	r.cacheKeyParts = append(r.cacheKeyParts, parts...)
+	return r
+}
+
 func (r *resContext) Fields(fields []string) ResponseContext {
 	r.fieldList = fields
 	return r
 }
 
+func (r *resContext) SearchColumns(columns []string) ResponseContext {
+	r.searchColumns = columns
+	return r
+}
+
+func (r *resContext) Preload(name string, options PreloadOptions) ResponseContext {
+	if nil == r.preloads {
+		r.preloads = map[string]PreloadOptions{}
+	}
+	r.preloads[name] = options
+	return r
+}
+
+func (r *resContext) Includes(relations []string) ResponseContext {
+	r.includeList = relations
+	return r
+}
+
+func (r *resContext) Conditional(column string) ResponseContext {
+	r.conditional = true
+	r.conditionalColumn = column
+	return r
+}
+
 func (r resContext) Response(res interface{}) Page {
-	p := r.Pagination
+	page := r.response(res)
+	if r.conditional && r.conditionalColumn == "" && nil == page.RawError {
+		content := page
+		content.CachedAt = nil
+		content.CacheHit = false
+		content.TotalToken = ""
+		marshal := json.Marshal
+		if nil != r.Pagination.Config && nil != r.Pagination.Config.JSONMarshal {
+			marshal = r.Pagination.Config.JSONMarshal
+		}
+		if data, err := marshal(content); nil == err {
+			page.ETag = fmt.Sprintf(`"%x"`, md5.Sum(data))
+			page.NotModified = notModified(r.Request, page.ETag, nil)
+		}
+	}
+
+	return page
+}
+
+func (r resContext) response(res interface{}) Page {
+	// copy pagination, so concurrent responses don't share the config
+	pagination := *r.Pagination
+	p := &pagination
 	query := r.Statement
+	if nil != p.Config {
+		c := *p.Config
+		p.Config = &c
+	}
 	p.Config = defaultConfig(p.Config)
 	p.Config.Statement = query.Statement
 	if p.Config.DefaultSize == 0 {
@@ -136,25 +236,37 @@ func (r resContext) Response(res interface{}) Page {
 	}
 
 	page := Page{}
-	pr := parseRequest(r.Request, *p.Config)
-	causes := createCauses(pr)
-	cKey := ""
-	var adapter gocache.AdapterInterface
-	var hasAdapter bool = false
-
-	if nil != p.Config.CacheAdapter {
-		cKey = createCacheKey(r.cachePrefix, pr)
-		adapter = p.Config.CacheAdapter
-		hasAdapter = true
-		if cKey != "" && adapter.IsValid(cKey) {
-			if cache, err := adapter.Get(cKey); nil == err {
-				page.Items = res
-				if err := p.Config.JSONUnmarshal([]byte(cache), &page); nil == err {
-					return page
-				}
-			}
+	config := *p.Config
+	if len(r.searchColumns) > 0 {
+		config.SearchColumns = r.searchColumns
+	}
+	if len(r.preloads) > 0 {
+		preloads := map[string]PreloadOptions{}
+		for name, options := range config.Preloads {
+			preloads[name] = options
+		}
+		for name, options := range r.preloads {
+			preloads[name] = options
+		}
+		config.Preloads = preloads
+	}
+	pr := parseRequest(r.Request, config)
+	page.Limits = pageLimits(config)
+	if nil != pr.Error {
+		page.Items = res
+		page.Page = pr.Page
+		page.Size = pr.Size
+		page.RawError = pr.Error
+		if p.Config.ErrorEnabled {
+			page.Error = true
+			page.ErrorMessage = pr.Error.Error()
 		}
+		return page
+	}
+	if len(p.Config.Scopes) > 0 {
+		query = applyScopes(query, requestContext(r.Request, query.Statement.Context), p.Config.Scopes)
 	}
+	causes := createCauses(pr)
 
 	dbs := query.Statement.DB.Session(&gorm.Session{NewDB: true})
 	var selects []string
@@ -188,6 +300,35 @@ func (r resContext) Response(res interface{}) Page {
 		}
 	}
 
+	includes := []string{}
+	if len(r.includeList) > 0 && len(pr.Includes) > 0 {
+		sch := modelSchema(query.Statement)
+		query = query.Session(&gorm.Session{})
+		for _, name := range pr.Includes {
+			if !contains(r.includeList, name) {
+				continue
+			}
+			slices := strings.Split(name, ".")
+			for i := range slices {
+				slices[i] = strcase.ToCamel(slices[i])
+			}
+			relation := strings.Join(slices, ".")
+			if rel, ok := sch.Relationships.Relations[relation]; ok && (rel.Type == schema.BelongsTo || rel.Type == schema.HasOne) {
+				joined := false
+				for _, join := range query.Statement.Joins {
+					joined = joined || join.Name == relation
+				}
+				if !joined {
+					query = query.Joins(relation)
+				}
+				continue
+			}
+			if _, ok := pr.Preloads[name]; !ok {
+				includes = append(includes, relation)
+			}
+		}
+	}
+
 	result := dbs.
 		Unscoped().
 		Table("(?) AS s", query)
@@ -200,67 +341,991 @@ func (r resContext) Response(res interface{}) Page {
 		result = result.Where(causes.WhereString, causes.Params...)
 	}
 
-	result = result.Count(&page.Total).
-		Limit(int(causes.Limit)).
-		Offset(int(causes.Offset))
+	if len(causes.Sorts) > 0 {
+		for _, sort := range causes.Sorts {
+			result = result.Order(orderExpression(sort, pr.Config))
+		}
+	}
+
+	// total counted by conditional request, so the page is counted once
+	conditionalTotal := int64(-1)
+	if r.conditional && r.conditionalColumn != "" {
+		countQuery := dbs.Unscoped().Table("(?) AS s", query)
+		if len(causes.Params) > 0 || len(causes.WhereString) > 0 {
+			countQuery = countQuery.Where(causes.WhereString, causes.Params...)
+		}
+		var lastModified interface{}
+		var total int64
+		row := countQuery.Select("MAX(" + columnName(r.conditionalColumn, pr.Config) + "), COUNT(*)").Row()
+		if err := row.Scan(&lastModified, &total); nil == err {
+			conditionalTotal = total
+			sql, vars := renderSQL(result.Session(&gorm.Session{}).
+				Limit(int(causes.Limit)).
+				Offset(int(causes.Offset)))
+			page.ETag = fmt.Sprintf(`W/"%x"`, md5.Sum([]byte(fmt.Sprint(sql, vars, lastModified, total))))
+			if modified, ok := toTime(lastModified); ok {
+				modified = modified.UTC().Truncate(time.Second)
+				page.LastModified = &modified
+			}
+			if notModified(r.Request, page.ETag, page.LastModified) {
+				page.NotModified = true
+				page.Items = res
+				page.Total = total
+				page.Page = pr.Page
+				page.Size = pr.Size
+				return page
+			}
+		}
+	}
 
-	page.RawError = result.Error
+	cKey := ""
+	var adapter gocache.AdapterInterface
+	var hasAdapter bool = false
+	var ck cacheKey
 
-	if result.Error != nil && p.Config.ErrorEnabled {
-		page.Error = true
-		page.ErrorMessage = result.Error.Error()
+	totalKey := ""
+	if (nil != p.Config.CacheAdapter && r.cachePrefix != "" && p.Config.TotalCacheEnabled) || p.Config.TotalTokenSecret != "" {
+		countQuery := dbs.Unscoped().Table("(?) AS s", query)
+		if len(causes.Params) > 0 || len(causes.WhereString) > 0 {
+			countQuery = countQuery.Where(causes.WhereString, causes.Params...)
+		}
+		sql, vars := renderSQL(countQuery)
+		totalKey = createCacheKey(r.cachePrefix+"total:", cacheKey{
+			Request: pageRequest{Config: pr.Config},
+			SQL:     sql,
+			Vars:    vars,
+			Parts:   r.cacheKeyParts,
+		})
 	}
 
-	if nil != query.Statement.Preloads {
-		for table, args := range query.Statement.Preloads {
-			result = result.Preload(table, args...)
+	load := func(res interface{}, ctx context.Context) Page {
+		page := page
+		result := result
+		dbs := dbs
+		if nil != ctx {
+			result = result.WithContext(ctx)
+			dbs = dbs.WithContext(ctx)
+		}
+
+		counted := false
+		if conditionalTotal >= 0 {
+			page.Total = conditionalTotal
+			counted = true
+			setCachedTotal(p, totalKey, r.cachePrefix+"total:", page.Total, query, pr, includes)
+		} else if total, ok := verifyTotalToken(pr.TotalToken, totalKey, *p.Config); ok {
+			page.Total = total
+			counted = true
+		} else if total, ok := getCachedTotal(totalKey, r.cachePrefix+"total:", r.cacheTTL, *p.Config); ok {
+			page.Total = total
+			counted = true
+		}
+		if !counted {
+			result = result.Count(&page.Total)
+			if nil == result.Error {
+				setCachedTotal(p, totalKey, r.cachePrefix+"total:", page.Total, query, pr, includes)
+			}
+		}
+		result = result.Limit(int(causes.Limit)).
+			Offset(int(causes.Offset))
+
+		page.RawError = result.Error
+
+		if result.Error != nil && p.Config.ErrorEnabled {
+			page.Error = true
+			page.ErrorMessage = result.Error.Error()
+		}
+
+		if nil != query.Statement.Preloads {
+			for table, args := range query.Statement.Preloads {
+				if _, ok := pr.Preloads[strcase.ToSnake(table)]; ok {
+					continue
+				}
+				result = result.Preload(table, args...)
+			}
+		}
+		for _, relation := range includes {
+			if _, ok := query.Statement.Preloads[relation]; !ok {
+				result = result.Preload(relation)
+			}
+		}
+		rs := result.Find(res)
+		if nil == page.RawError {
+			page.RawError = rs.Error
 		}
+
+		if rs.Error != nil && p.Config.ErrorEnabled && !page.Error {
+			page.Error = true
+			page.ErrorMessage = rs.Error.Error()
+		}
+
+		page.Items = res
+		if nil == rs.Error {
+			for name, preload := range pr.Preloads {
+				totals, err := preloadPage(dbs, res, name, preload, config)
+				if nil != err {
+					if nil == page.RawError {
+						page.RawError = err
+					}
+					if p.Config.ErrorEnabled && !page.Error {
+						page.Error = true
+						page.ErrorMessage = err.Error()
+					}
+					continue
+				}
+				if config.Preloads[name].TotalEnabled {
+					if nil == page.PreloadTotals {
+						page.PreloadTotals = map[string][]int64{}
+					}
+					page.PreloadTotals[name] = totals
+				}
+			}
+		}
+		if geo, ok := geoColumn(*p.Config); ok && geo.DistanceEnabled && nil == rs.Error {
+			if near := findNear(pr.Filters); nil != near {
+				page.Distances = itemDistances(res, geo, near, *p.Config)
+			}
+		}
+		f := float64(page.Total) / float64(causes.Limit)
+		if math.Mod(f, 1.0) > 0 {
+			f = f + 1
+		}
+		f = math.Max(f, 1)
+
+		page.TotalPages = int64(f)
+		page.MaxPage = page.TotalPages - 1 + p.Config.PageStart
+		page.Page = int64(pr.Page)
+		page.Size = int64(pr.Size)
+		page.Visible = rs.RowsAffected
+
+		if page.Total < 1 {
+			page.MaxPage = p.Config.PageStart
+			page.TotalPages = 0
+		}
+		page.First = causes.Offset < 1
+		page.Last = page.Page >= page.MaxPage
+		if p.Config.TotalTokenSecret != "" && totalKey != "" && nil == page.RawError {
+			page.TotalToken = createTotalToken(page.Total, totalKey, *p.Config)
+		}
+
+		// error page doesn't replace the cached page
+		if hasAdapter && cKey != "" && nil == page.RawError {
+			cachedAt := time.Now()
+			page.CachedAt = &cachedAt
+			start := time.Now()
+			if cache, err := encodeCache(page, *p.Config); nil != err {
+				cacheSetError(*p.Config, r.cachePrefix, err, start)
+			} else if err := adapter.Set(cKey, cache); err != nil {
+				cacheSetError(*p.Config, r.cachePrefix, err, start)
+			} else if nil != p.cacheTags {
+				p.cacheTags.add(adapter, cKey, dependentTables(query, pr, includes))
+			}
+		}
+
+		return page
 	}
-	if len(causes.Sorts) > 0 {
-		for _, sort := range causes.Sorts {
-			result = result.Order(sort.Column + " " + sort.Direction)
+
+	if nil != p.Config.CacheAdapter || p.Config.SingleflightEnabled {
+		sql, vars := renderSQL(result.Session(&gorm.Session{}).
+			Limit(int(causes.Limit)).
+			Offset(int(causes.Offset)))
+		ck = cacheKey{
+			Request:  pr,
+			SQL:      sql,
+			Vars:     vars,
+			Type:     resultType(res),
+			Parts:    r.cacheKeyParts,
+			Preloads: statementPreloads(query.Statement),
 		}
 	}
 
-	rs := result.Find(res)
-	if nil == page.RawError {
-		page.RawError = rs.Error
+	if nil != p.Config.CacheAdapter {
+		cKey = createCacheKey(r.cachePrefix, ck)
+		adapter = p.Config.CacheAdapter
+		hasAdapter = true
+		if cKey != "" {
+			start := time.Now()
+			if !adapter.IsValid(cKey) {
+				cacheMiss(*p.Config, r.cachePrefix, start)
+			} else if cache, err := adapter.Get(cKey); nil != err {
+				cacheGetError(*p.Config, r.cachePrefix, err, start)
+			} else {
+				cached := page
+				cached.Items = res
+				if err := decodeCache(cache, &cached, *p.Config); nil != err {
+					cacheGetError(*p.Config, r.cachePrefix, err, start)
+				} else {
+					cached.CacheHit = true
+					if r.cacheTTL <= 0 || nil == cached.CachedAt {
+						cacheHit(*p.Config, r.cachePrefix, start)
+						return cached
+					}
+					age := time.Since(*cached.CachedAt)
+					if age <= r.cacheTTL {
+						cacheHit(*p.Config, r.cachePrefix, start)
+						return cached
+					}
+					if fresh := newResult(res); age <= r.cacheTTL+r.cacheStale && nil != fresh {
+						if _, loading := pageRefreshes.LoadOrStore(cKey, true); !loading {
+							go func() {
+								defer pageRefreshes.Delete(cKey)
+								load(fresh, detachedContext{query.Statement.Context})
+							}()
+						}
+						cacheHit(*p.Config, r.cachePrefix, start)
+						return cached
+					}
+					cacheMiss(*p.Config, r.cachePrefix, start)
+				}
+			}
+		}
 	}
 
-	if rs.Error != nil && p.Config.ErrorEnabled && !page.Error {
-		page.Error = true
-		page.ErrorMessage = rs.Error.Error()
+	return loadPage(res, load, ck, *p.Config)
+}
+
+// CacheObserver receives cache events with the key prefix and duration of cache lookup or store
+type CacheObserver interface {
+	OnCacheHit(prefix string, duration time.Duration)
+	OnCacheMiss(prefix string, duration time.Duration)
+	OnCacheSetError(prefix string, err error, duration time.Duration)
+	OnCacheGetError(prefix string, err error, duration time.Duration)
+}
+
+// ExpvarObserver publishes cache counters and durations per key prefix with expvar,
+// eg: {"paginate_cache": {"article": {"hit": 10, "hit_ns": 52000, "miss": 2, ...}}}
+type ExpvarObserver struct {
+	vars  *expvar.Map
+	mutex sync.Mutex
+}
+
+// NewExpvarObserver creates cache observer published as expvar name,
+// the existing expvar map is reused if the name is already published
+func NewExpvarObserver(name string) *ExpvarObserver {
+	if vars, ok := expvar.Get(name).(*expvar.Map); ok {
+		return &ExpvarObserver{vars: vars}
+	}
+
+	return &ExpvarObserver{vars: expvar.NewMap(name)}
+}
+
+// OnCacheHit counts cache hit
+func (o *ExpvarObserver) OnCacheHit(prefix string, duration time.Duration) {
+	o.add(prefix, "hit", duration)
+}
+
+// OnCacheMiss counts cache miss
+func (o *ExpvarObserver) OnCacheMiss(prefix string, duration time.Duration) {
+	o.add(prefix, "miss", duration)
+}
+
+// OnCacheSetError counts failed cache store
+func (o *ExpvarObserver) OnCacheSetError(prefix string, err error, duration time.Duration) {
+	o.add(prefix, "set_error", duration)
+}
+
+// OnCacheGetError counts failed cache lookup
+func (o *ExpvarObserver) OnCacheGetError(prefix string, err error, duration time.Duration) {
+	o.add(prefix, "get_error", duration)
+}
+
+func (o *ExpvarObserver) add(prefix string, event string, duration time.Duration) {
+	o.mutex.Lock()
+	vars, ok := o.vars.Get(prefix).(*expvar.Map)
+	if !ok {
+		vars = new(expvar.Map).Init()
+		o.vars.Set(prefix, vars)
+	}
+	o.mutex.Unlock()
+	vars.Add(event, 1)
+	vars.Add(event+"_ns", int64(duration))
+}
+
+func cacheHit(config Config, prefix string, start time.Time) {
+	if nil != config.CacheObserver {
+		config.CacheObserver.OnCacheHit(prefix, time.Since(start))
 	}
+}
 
-	page.Items = res
-	f := float64(page.Total) / float64(causes.Limit)
-	if math.Mod(f, 1.0) > 0 {
-		f = f + 1
+func cacheMiss(config Config, prefix string, start time.Time) {
+	if nil != config.CacheObserver {
+		config.CacheObserver.OnCacheMiss(prefix, time.Since(start))
 	}
-	f = math.Max(f, 1)
+}
 
-	page.TotalPages = int64(f)
-	page.MaxPage = page.TotalPages - 1 + p.Config.PageStart
-	page.Page = int64(pr.Page)
-	page.Size = int64(pr.Size)
-	page.Visible = rs.RowsAffected
+// cacheSetError logs the error if there is no observer
+func cacheSetError(config Config, prefix string, err error, start time.Time) {
+	if nil != config.CacheObserver {
+		config.CacheObserver.OnCacheSetError(prefix, err, time.Since(start))
+	} else {
+		log.Println(err)
+	}
+}
 
-	if page.Total < 1 {
-		page.MaxPage = p.Config.PageStart
-		page.TotalPages = 0
+// cacheGetError logs the error if there is no observer
+func cacheGetError(config Config, prefix string, err error, start time.Time) {
+	if nil != config.CacheObserver {
+		config.CacheObserver.OnCacheGetError(prefix, err, time.Since(start))
+	} else {
+		log.Println(err)
 	}
-	page.First = causes.Offset < 1
-	page.Last = page.Page >= page.MaxPage
+}
 
-	if hasAdapter && cKey != "" {
-		if cache, err := p.Config.JSONMarshal(page); nil == err {
-			if err := adapter.Set(cKey, string(cache)); err != nil {
-				log.Println(err)
+// cacheHeader prefix of versioned cache entry, followed by codec name
+const cacheHeader = "paginate:v1:"
+
+// CacheCodec encodes cached pages
+type CacheCodec interface {
+	Name() string
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes cache entry with encoding/json,
+// Config.JSONMarshal and Config.JSONUnmarshal are used if Marshal and Unmarshal are empty
+type JSONCodec struct {
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte, v interface{}) error
+}
+
+// Name of codec
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+// Encode value
+func (c JSONCodec) Encode(v interface{}) ([]byte, error) {
+	if nil != c.Marshal {
+		return c.Marshal(v)
+	}
+	return json.Marshal(v)
+}
+
+// Decode value
+func (c JSONCodec) Decode(data []byte, v interface{}) error {
+	if nil != c.Unmarshal {
+		return c.Unmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// configCodec sets json functions of config to the json codec, including the wrapped codec
+func configCodec(codec CacheCodec, config Config) CacheCodec {
+	switch c := codec.(type) {
+	case JSONCodec:
+		if nil == c.Marshal {
+			c.Marshal = config.JSONMarshal
+		}
+		if nil == c.Unmarshal {
+			c.Unmarshal = config.JSONUnmarshal
+		}
+		return c
+	case GzipCodec:
+		c.Codec = configCodec(c.codec(), config)
+		return c
+	case ZstdCodec:
+		c.Codec = configCodec(c.codec(), config)
+		return c
+	}
+
+	return codec
+}
+
+// GobCodec encodes cache entry with encoding/gob
+type GobCodec struct{}
+
+// Name of codec
+func (GobCodec) Name() string {
+	return "gob"
+}
+
+// Encode value
+func (GobCodec) Encode(v interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(v)
+
+	return buf.Bytes(), err
+}
+
+// Decode value
+func (GobCodec) Decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// GzipCodec compresses encoded cache entry with gzip, Codec defaults to JSONCodec
+type GzipCodec struct {
+	Codec CacheCodec
+	Level int
+}
+
+func (c GzipCodec) codec() CacheCodec {
+	if nil == c.Codec {
+		return JSONCodec{}
+	}
+
+	return c.Codec
+}
+
+// Name of codec
+func (c GzipCodec) Name() string {
+	return "gzip+" + c.codec().Name()
+}
+
+// Encode value
+func (c GzipCodec) Encode(v interface{}) ([]byte, error) {
+	data, err := c.codec().Encode(v)
+	if nil != err {
+		return nil, err
+	}
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	buf := bytes.Buffer{}
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if nil != err {
+		return nil, err
+	}
+	if _, err := w.Write(data); nil != err {
+		return nil, err
+	}
+	if err := w.Close(); nil != err {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode value
+func (c GzipCodec) Decode(data []byte, v interface{}) error {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if nil != err {
+		return err
+	}
+	defer r.Close()
+	data, err = io.ReadAll(r)
+	if nil != err {
+		return err
+	}
+
+	return c.codec().Decode(data, v)
+}
+
+// ZstdCodec compresses encoded cache entry with zstd, Codec defaults to JSONCodec
+type ZstdCodec struct {
+	Codec CacheCodec
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func (c ZstdCodec) codec() CacheCodec {
+	if nil == c.Codec {
+		return JSONCodec{}
+	}
+
+	return c.Codec
+}
+
+// Name of codec
+func (c ZstdCodec) Name() string {
+	return "zstd+" + c.codec().Name()
+}
+
+// Encode value
+func (c ZstdCodec) Encode(v interface{}) ([]byte, error) {
+	data, err := c.codec().Encode(v)
+	if nil != err {
+		return nil, err
+	}
+	zstdOnce.Do(initZstd)
+
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+// Decode value
+func (c ZstdCodec) Decode(data []byte, v interface{}) error {
+	zstdOnce.Do(initZstd)
+	data, err := zstdDecoder.DecodeAll(data, nil)
+	if nil != err {
+		return err
+	}
+
+	return c.codec().Decode(data, v)
+}
+
+// initZstd creates shared zstd encoder and decoder, both are safe for concurrent EncodeAll and DecodeAll
+func initZstd() {
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+}
+
+// cacheCodec finds codec by name from config and built in codecs
+func cacheCodec(name string, config Config) CacheCodec {
+	for _, codec := range append([]CacheCodec{config.CacheCodec}, config.CacheCodecs...) {
+		if nil != codec && codec.Name() == name {
+			return codec
+		}
+	}
+	switch {
+	case name == "json":
+		return JSONCodec{}
+	case name == "gob":
+		return GobCodec{}
+	case strings.HasPrefix(name, "gzip+"):
+		if codec := cacheCodec(strings.TrimPrefix(name, "gzip+"), config); nil != codec {
+			return GzipCodec{Codec: codec}
+		}
+	case strings.HasPrefix(name, "zstd+"):
+		if codec := cacheCodec(strings.TrimPrefix(name, "zstd+"), config); nil != codec {
+			return ZstdCodec{Codec: codec}
+		}
+	}
+
+	return nil
+}
+
+// encodeCache encodes page into cache entry.
+// Without CacheCodec the page is stored as plain json like before,
+// otherwise the entry has versioned header with codec name,
+// page and items are encoded separately so items can be decoded into the result directly
+func encodeCache(page Page, config Config) (string, error) {
+	if nil == config.CacheCodec {
+		data, err := config.JSONMarshal(page)
+		return string(data), err
+	}
+	codec := configCodec(config.CacheCodec, config)
+	items := page.Items
+	// fields of the current request are not cached
+	page.Items = nil
+	page.ETag = ""
+	page.LastModified = nil
+	page.NotModified = false
+	page.RawError = nil
+	meta, err := codec.Encode(page)
+	if nil != err {
+		return "", err
+	}
+	body, err := codec.Encode(items)
+	if nil != err {
+		return "", err
+	}
+	frame := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(meta)+len(body))
+	frame = frame[:binary.PutUvarint(frame, uint64(len(meta)))]
+	frame = append(append(frame, meta...), body...)
+
+	return cacheHeader + config.CacheCodec.Name() + ":" + base64.StdEncoding.EncodeToString(frame), nil
+}
+
+// decodeCache decodes cache entry into page, page.Items must be set with the result.
+// Entries without header are decoded as plain json
+func decodeCache(value string, page *Page, config Config) error {
+	if !strings.HasPrefix(value, cacheHeader) {
+		return config.JSONUnmarshal([]byte(value), page)
+	}
+	value = strings.TrimPrefix(value, cacheHeader)
+	i := strings.Index(value, ":")
+	if i < 0 {
+		return fmt.Errorf("invalid cache entry")
+	}
+	codec := cacheCodec(value[:i], config)
+	if nil == codec {
+		return fmt.Errorf("unknown cache codec %s", value[:i])
+	}
+	codec = configCodec(codec, config)
+	frame, err := base64.StdEncoding.DecodeString(value[i+1:])
+	if nil != err {
+		return err
+	}
+	size, n := binary.Uvarint(frame)
+	if n <= 0 || uint64(len(frame)-n) < size {
+		return fmt.Errorf("invalid cache entry")
+	}
+	// cached fields are decoded over the page, like plain json entries
+	cached := *page
+	if err := codec.Decode(frame[n:n+int(size)], &cached); nil != err {
+		return err
+	}
+	if err := codec.Decode(frame[n+int(size):], page.Items); nil != err {
+		return err
+	}
+	cached.Items = page.Items
+	cached.ETag = page.ETag
+	cached.LastModified = page.LastModified
+	cached.NotModified = page.NotModified
+	*page = cached
+
+	return nil
+}
+
+// cachedTotal struct
+type cachedTotal struct {
+	Total    int64     `json:"total"`
+	CachedAt time.Time `json:"cached_at"`
+}
+
+// toTime converts database value into time
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case []byte:
+		return toTime(string(v))
+	case string:
+		layouts := []string{
+			time.RFC3339Nano,
+			"2006-01-02 15:04:05.999999999-07:00",
+			"2006-01-02 15:04:05.999999999",
+			"2006-01-02T15:04:05.999999999",
+		}
+		for _, layout := range layouts {
+			if t, err := time.Parse(layout, v); nil == err {
+				return t, true
 			}
 		}
 	}
 
-	return page
+	return time.Time{}, false
+}
+
+// requestHeader returns header value of net/http or fasthttp request
+func requestHeader(r interface{}, key string) string {
+	switch request := r.(type) {
+	case *http.Request:
+		if nil != request {
+			return request.Header.Get(key)
+		}
+	case http.Request:
+		return request.Header.Get(key)
+	case *fasthttp.Request:
+		if nil != request {
+			return string(request.Header.Peek(key))
+		}
+	}
+
+	return ""
+}
+
+// notModified checks If-None-Match and If-Modified-Since request headers
+func notModified(r interface{}, etag string, lastModified *time.Time) bool {
+	if ifNoneMatch := requestHeader(r, "If-None-Match"); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ifModifiedSince := requestHeader(r, "If-Modified-Since"); ifModifiedSince != "" && nil != lastModified {
+		if t, err := http.ParseTime(ifModifiedSince); nil == err && !lastModified.After(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WriteNetHTTPHeaders writes ETag and Last-Modified headers of the page,
+// it writes 304 status and returns true when the page is not modified
+func WriteNetHTTPHeaders(w http.ResponseWriter, page Page) bool {
+	if page.ETag != "" {
+		w.Header().Set("ETag", page.ETag)
+	}
+	if nil != page.LastModified {
+		w.Header().Set("Last-Modified", page.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if page.NotModified {
+		w.WriteHeader(http.StatusNotModified)
+	}
+
+	return page.NotModified
+}
+
+// WriteFastHTTPHeaders writes ETag and Last-Modified headers of the page,
+// it sets 304 status and returns true when the page is not modified
+func WriteFastHTTPHeaders(ctx *fasthttp.RequestCtx, page Page) bool {
+	if page.ETag != "" {
+		ctx.Response.Header.Set("ETag", page.ETag)
+	}
+	if nil != page.LastModified {
+		ctx.Response.Header.Set("Last-Modified", page.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if page.NotModified {
+		ctx.SetStatusCode(fasthttp.StatusNotModified)
+	}
+
+	return page.NotModified
+}
+
+// getCachedTotal returns total from the total cache
+func getCachedTotal(key string, prefix string, ttl time.Duration, config Config) (int64, bool) {
+	if key == "" || !config.TotalCacheEnabled || nil == config.CacheAdapter {
+		return 0, false
+	}
+	start := time.Now()
+	if !config.CacheAdapter.IsValid(key) {
+		cacheMiss(config, prefix, start)
+		return 0, false
+	}
+	value, err := config.CacheAdapter.Get(key)
+	if nil != err {
+		cacheGetError(config, prefix, err, start)
+		return 0, false
+	}
+	total := cachedTotal{}
+	if err := config.JSONUnmarshal([]byte(value), &total); nil != err {
+		cacheGetError(config, prefix, err, start)
+		return 0, false
+	}
+	if ttl > 0 && time.Since(total.CachedAt) > ttl {
+		cacheMiss(config, prefix, start)
+		return 0, false
+	}
+	cacheHit(config, prefix, start)
+
+	return total.Total, true
+}
+
+// setCachedTotal stores total into the total cache
+func setCachedTotal(p *Pagination, key string, prefix string, total int64, query *gorm.DB, pr pageRequest, includes []string) {
+	if key == "" || !p.Config.TotalCacheEnabled || nil == p.Config.CacheAdapter {
+		return
+	}
+	start := time.Now()
+	value, err := p.Config.JSONMarshal(cachedTotal{Total: total, CachedAt: time.Now()})
+	if nil != err {
+		cacheSetError(*p.Config, prefix, err, start)
+		return
+	}
+	if err := p.Config.CacheAdapter.Set(key, string(value)); nil != err {
+		cacheSetError(*p.Config, prefix, err, start)
+	} else if nil != p.cacheTags {
+		p.cacheTags.add(p.Config.CacheAdapter, key, dependentTables(query, pr, includes))
+	}
+}
+
+// createTotalToken signs the total of filter set, so the client can echo
+// the token to skip counting on the next pages
+func createTotalToken(total int64, key string, config Config) string {
+	issuedAt := time.Now().Unix()
+	mac := hmac.New(sha256.New, []byte(config.TotalTokenSecret))
+	mac.Write([]byte(fmt.Sprintf("%s:%d:%d", key, total, issuedAt)))
+
+	return fmt.Sprintf("%d.%d.%x", total, issuedAt, mac.Sum(nil))
+}
+
+// defaultTotalTokenTTL maximum age of total token if TotalTokenTTL is not set
+const defaultTotalTokenTTL = 10 * time.Minute
+
+// verifyTotalToken returns total of a valid token of the same filter set
+func verifyTotalToken(token string, key string, config Config) (int64, bool) {
+	slices := strings.Split(token, ".")
+	if config.TotalTokenSecret == "" || key == "" || len(slices) != 3 {
+		return 0, false
+	}
+	total, err := strconv.ParseInt(slices[0], 10, 64)
+	if nil != err || total < 0 {
+		return 0, false
+	}
+	issuedAt, err := strconv.ParseInt(slices[1], 10, 64)
+	if nil != err {
+		return 0, false
+	}
+	ttl := config.TotalTokenTTL
+	if ttl <= 0 {
+		ttl = defaultTotalTokenTTL
+	}
+	if age := time.Since(time.Unix(issuedAt, 0)); age > ttl || age < -time.Minute {
+		return 0, false
+	}
+	mac := hmac.New(sha256.New, []byte(config.TotalTokenSecret))
+	mac.Write([]byte(fmt.Sprintf("%s:%d:%d", key, total, issuedAt)))
+	if !hmac.Equal([]byte(fmt.Sprintf("%x", mac.Sum(nil))), []byte(slices[2])) {
+		return 0, false
+	}
+
+	return total, true
+}
+
+// loadPage runs load, identical concurrent loads share one query if singleflight is enabled
+func loadPage(res interface{}, load func(interface{}, context.Context) Page, ck cacheKey, config Config) Page {
+	if config.SingleflightEnabled {
+		if fKey := createCacheKey("paginate_flight:", ck); fKey != "" {
+			call, shared := pageFlights.do(fKey, func() Page {
+				return load(res, nil)
+			}, config.JSONMarshal)
+			if !shared {
+				return call.page
+			}
+			page := call.page
+			page.Items = res
+			if nil != call.data {
+				if err := config.JSONUnmarshal(call.data, &page); nil != err {
+					log.Println(err)
+				}
+			}
+			return page
+		}
+	}
+
+	return load(res, nil)
+}
+
+var pageRefreshes sync.Map
+
+// newResult creates empty result with the same type of res
+func newResult(res interface{}) interface{} {
+	t := reflect.TypeOf(res)
+	if nil == t || t.Kind() != reflect.Ptr {
+		return nil
+	}
+
+	return reflect.New(t.Elem()).Interface()
+}
+
+// detachedContext keeps values of the parent context without its cancellation,
+// it is used to refresh stale cache after the request is done
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	if nil == c.parent {
+		return nil
+	}
+	return c.parent.Value(key)
+}
+
+// dependentTables returns tables read by the statement, including joined,
+// preloaded and filtered relation tables
+func dependentTables(query *gorm.DB, pr pageRequest, includes []string) []string {
+	sch := modelSchema(query.Statement)
+	if nil == sch || query.Statement.SQL.Len() > 0 {
+		return []string{"*"}
+	}
+
+	tables := []string{sch.Table}
+	addRelation := func(name string) {
+		current := sch
+		for _, segment := range strings.Split(name, ".") {
+			rel, ok := current.Relationships.Relations[strcase.ToCamel(segment)]
+			if !ok || nil == rel.FieldSchema {
+				return
+			}
+			if !contains(tables, rel.FieldSchema.Table) {
+				tables = append(tables, rel.FieldSchema.Table)
+			}
+			if nil != rel.JoinTable && !contains(tables, rel.JoinTable.Table) {
+				tables = append(tables, rel.JoinTable.Table)
+			}
+			current = rel.FieldSchema
+		}
+	}
+	for _, join := range query.Statement.Joins {
+		addRelation(join.Name)
+	}
+	for name := range query.Statement.Preloads {
+		addRelation(name)
+	}
+	for name := range pr.Preloads {
+		addRelation(name)
+	}
+	for _, name := range includes {
+		addRelation(name)
+	}
+	for _, so := range pr.Sorts {
+		if slices := strings.Split(so.Column, "."); len(slices) > 1 {
+			addRelation(slices[0])
+		}
+	}
+	var addFilters func(f pageFilters)
+	addFilters = func(f pageFilters) {
+		if subs, ok := f.Value.([]pageFilters); ok && !f.Single {
+			for i := range subs {
+				addFilters(subs[i])
+			}
+			return
+		}
+		if relation, _, _ := splitRelationColumn(f.Column); relation != "" {
+			addRelation(relation)
+		}
+	}
+	addFilters(pr.Filters)
+	addFilters(pr.Search)
+
+	return tables
+}
+
+// applyScopes applies config scopes to the statement, existing conditions are grouped
+// so the scope conditions can't be bypassed with OR
+func applyScopes(query *gorm.DB, ctx context.Context, scopes []func(context.Context, *gorm.DB) *gorm.DB) *gorm.DB {
+	if query.Statement.SQL.Len() > 0 {
+		query = query.Session(&gorm.Session{NewDB: true, Context: ctx}).Table("(?) AS t", query)
+	} else {
+		query = query.Session(&gorm.Session{Context: ctx})
+		if c, ok := query.Statement.Clauses["WHERE"]; ok {
+			if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 1 {
+				c.Expression = clause.Where{Exprs: []clause.Expression{clause.AndConditions{Exprs: where.Exprs}}}
+				query.Statement.Clauses["WHERE"] = c
+			}
+		}
+	}
+	for _, scope := range scopes {
+		query = scope(ctx, query)
+	}
+
+	return query
+}
+
+// requestContext returns context of net/http request or the default context
+func requestContext(r interface{}, ctx context.Context) context.Context {
+	if netHTTP, isNetHTTP := r.(*http.Request); isNetHTTP && nil != netHTTP {
+		return netHTTP.Context()
+	}
+	if netHTTP, isNetHTTP := r.(http.Request); isNetHTTP {
+		return netHTTP.Context()
+	}
+	if nil == ctx {
+		return context.Background()
+	}
+
+	return ctx
+}
+
+// renderSQL renders sql and vars of statement without executing the statement.
+// The statement is built without query callbacks, so logger and plugins don't see a query that never runs
+func renderSQL(query *gorm.DB) (string, []interface{}) {
+	ctx := query.Statement.Context
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	// session with context clones the statement
+	tx := query.Session(&gorm.Session{DryRun: true, Logger: logger.Discard, Context: ctx})
+	stmt := tx.Statement
+	stmt.Dest = &[]map[string]interface{}{}
+	if nil == stmt.Model {
+		stmt.Model = stmt.Dest
+	}
+	stmt.Parse(stmt.Model)
+	stmt.ReflectValue = reflect.ValueOf(stmt.Dest).Elem()
+	callbacks.BuildQuerySQL(tx)
+
+	return stmt.SQL.String(), stmt.Vars
 }
 
 // New Pagination instance
@@ -310,30 +1375,124 @@ func createFilters(filterParams interface{}, p *pageRequest) {
 	f, ok := filterParams.([]interface{})
 	s, ok2 := filterParams.(string)
 	if ok {
+		if err := validateFilters(f, p.Config); nil != err {
+			p.Error = err
+			return
+		}
 		p.Filters = arrayToFilter(f, p.Config)
 		p.Filters.Fields = p.Fields
 	} else if ok2 {
 		iface := []interface{}{}
 		if e := p.Config.JSONUnmarshal([]byte(s), &iface); nil == e && len(iface) > 0 {
+			if err := validateFilters(iface, p.Config); nil != err {
+				p.Error = err
+				return
+			}
 			p.Filters = arrayToFilter(iface, p.Config)
 		}
 		p.Filters.Fields = p.Fields
 	}
+
+	if err := coerceFilters(&p.Filters, modelSchema(p.Config.Statement), p.Config); nil != err {
+		p.Error = err
+	}
+}
+
+// validateFilters checks complexity limits of filters before the filters are parsed
+func validateFilters(arr []interface{}, config Config) error {
+	conditions := 0
+	var validate func(arr []interface{}, depth int) error
+	validate = func(arr []interface{}, depth int) error {
+		if config.MaxFilterDepth > 0 && depth > config.MaxFilterDepth {
+			return &LimitError{Param: "filters depth", Value: int64(depth), Limit: int64(config.MaxFilterDepth)}
+		}
+
+		column, isCondition := arr[0].(string)
+		if !isCondition || (len(arr) != 2 && len(arr) != 3) {
+			for _, item := range arr {
+				if sub, ok := item.([]interface{}); ok && len(sub) > 0 {
+					if err := validate(sub, depth+1); nil != err {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+
+		conditions += len(strings.Split(column, ","))
+		if config.MaxFilterConditions > 0 && conditions > config.MaxFilterConditions {
+			return &LimitError{Param: "filters conditions", Value: int64(conditions), Limit: int64(config.MaxFilterConditions)}
+		}
+
+		operator := ""
+		if len(arr) == 3 {
+			operator, _ = arr[1].(string)
+			operator = strings.ToUpper(strings.TrimSpace(operator))
+		}
+		value := arr[len(arr)-1]
+		values, isList := value.([]interface{})
+		if isList && config.MaxFilterValues > 0 && len(values) > config.MaxFilterValues {
+			return &LimitError{Param: column + " values", Value: int64(len(values)), Limit: int64(config.MaxFilterValues)}
+		}
+		if operator == "BETWEEN" && (!isList || len(values) != 2) {
+			return &ValidationError{Column: column, Value: value, Message: "between requires 2 values"}
+		}
+		if strings.Contains(operator, "LIKE") && config.MaxFilterLikeLength > 0 {
+			if length := len(fmt.Sprintf("%v", value)); length > config.MaxFilterLikeLength {
+				return &LimitError{Param: column + " length", Value: int64(length), Limit: int64(config.MaxFilterLikeLength)}
+			}
+		}
+
+		return nil
+	}
+
+	if len(arr) < 1 {
+		return nil
+	}
+
+	return validate(arr, 1)
 }
 
 // createCauses func
 func createCauses(p pageRequest) requestQuery {
 	query := requestQuery{}
 	wheres, params := generateWhereCauses(p.Filters, p.Config)
+	if searchWheres, searchParams := generateWhereCauses(p.Search, p.Config); len(searchWheres) > 0 {
+		if len(wheres) > 0 {
+			wheres = append(append([]string{"("}, wheres...), ")", "AND")
+		}
+		wheres = append(wheres, searchWheres...)
+		params = append(params, searchParams...)
+	}
 	sorts := []sortOrder{}
 
 	for _, so := range p.Sorts {
-		so.Column = fieldName(so.Column)
-		if nil != p.Config.Statement {
-			so.Column = p.Config.Statement.Quote(so.Column)
+		if expression, ok := p.Config.SortExpressions[so.Column]; ok {
+			so.Column = "(" + sortExpression(expression, p.Config) + ")"
+			sorts = append(sorts, so)
+			continue
 		}
+		if isAggregateColumn(so.Column) {
+			if expr, ok := relationAggregate(so.Column, p.Config); ok {
+				so.Column = expr
+				sorts = append(sorts, so)
+			}
+			continue
+		}
+		if so.Column == "_distance" {
+			geo, hasGeo := geoColumn(p.Config)
+			near := findNear(p.Filters)
+			if !hasGeo || nil == near {
+				continue
+			}
+			so.Column = distanceExpression(geo, near, p.Config)
+			sorts = append(sorts, so)
+			continue
+		}
+		so.Column = columnName(so.Column, p.Config)
 		sorts = append(sorts, so)
 	}
+	sorts = append(sorts, primaryKeySorts(p)...)
 
 	query.Limit = p.Size
 	query.Offset = (p.Page - p.Config.PageStart) * p.Size
@@ -383,10 +1542,21 @@ func parsingNetHTTPRequest(r *http.Request, p *pageRequest) {
 		if !p.Config.CustomParamEnabled {
 			param.Size, _ = strconv.ParseInt(query.Get("size"), 10, 64)
 			param.Page, _ = strconv.ParseInt(query.Get("page"), 10, 64)
-			param.Sort = query.Get("sort")
-			param.Order = query.Get("order")
+			param.Sort = joinValues(func(key string) []string {
+				return query[key]
+			}, "sort", "sort[]")
+			param.Order = joinValues(func(key string) []string {
+				return query[key]
+			}, "order", "order[]")
 			param.Filters = query.Get("filters")
 			param.Fields = strings.Split(query.Get("fields"), ",")
+			param.Search = query.Get("q")
+			param.Timezone = query.Get("tz")
+			param.Include = strings.Split(query.Get("include"), ",")
+			param.TotalToken = query.Get("total_token")
+			generatePreloadParams(param, p.Config, func(key string) string {
+				return query.Get(key)
+			})
 		} else {
 			generateParams(param, p.Config, func(key string) string {
 				return query.Get(key)
@@ -428,10 +1598,24 @@ func parsingFastHTTPRequest(r *fasthttp.Request, p *pageRequest) {
 		if !p.Config.CustomParamEnabled {
 			param.Size, _ = strconv.ParseInt(string(query.Peek("size")), 10, 64)
 			param.Page, _ = strconv.ParseInt(string(query.Peek("page")), 10, 64)
-			param.Sort = string(query.Peek("sort"))
-			param.Order = string(query.Peek("order"))
+			peekValues := func(key string) []string {
+				values := []string{}
+				for _, value := range query.PeekMulti(key) {
+					values = append(values, string(value))
+				}
+				return values
+			}
+			param.Sort = joinValues(peekValues, "sort", "sort[]")
+			param.Order = joinValues(peekValues, "order", "order[]")
 			param.Filters = string(query.Peek("filters"))
 			param.Fields = strings.Split(string(query.Peek("fields")), ",")
+			param.Search = string(query.Peek("q"))
+			param.Timezone = string(query.Peek("tz"))
+			param.Include = strings.Split(string(query.Peek("include")), ",")
+			param.TotalToken = string(query.Peek("total_token"))
+			generatePreloadParams(param, p.Config, func(key string) string {
+				return string(query.Peek(key))
+			})
 		} else {
 			generateParams(param, p.Config, func(key string) string {
 				return string(query.Peek(key))
@@ -439,59 +1623,328 @@ func parsingFastHTTPRequest(r *fasthttp.Request, p *pageRequest) {
 		}
 	}
 
-	parsingQueryString(param, p)
+	parsingQueryString(param, p)
+}
+
+// limitPage clamps or rejects size, page and offset exceeding the configured limits
+func limitPage(p *pageRequest) error {
+	config := p.Config
+	if config.MaxSize > 0 && (p.Size > config.MaxSize || p.Size < 0) {
+		if config.RejectOverLimitEnabled {
+			return &LimitError{Param: "size", Value: p.Size, Limit: config.MaxSize}
+		}
+		p.Size = config.MaxSize
+	} else if p.Size < 0 && config.UnlimitedDisabled {
+		defaultSize := config.DefaultSize
+		if defaultSize < 1 {
+			defaultSize = 10
+		}
+		if config.RejectOverLimitEnabled {
+			return &LimitError{Param: "size", Value: p.Size, Limit: defaultSize}
+		}
+		p.Size = defaultSize
+	}
+
+	if config.MaxPage > 0 && p.Page > config.MaxPage {
+		if config.RejectOverLimitEnabled {
+			return &LimitError{Param: "page", Value: p.Page, Limit: config.MaxPage}
+		}
+		p.Page = config.MaxPage
+	}
+
+	if offset := (p.Page - config.PageStart) * p.Size; config.MaxOffset > 0 && p.Size > 0 && offset > config.MaxOffset {
+		if config.RejectOverLimitEnabled {
+			return &LimitError{Param: "offset", Value: offset, Limit: config.MaxOffset}
+		}
+		p.Page = config.MaxOffset/p.Size + config.PageStart
+	}
+
+	return nil
+}
+
+// pageLimits returns the configured limits of page
+func pageLimits(config Config) *PageLimits {
+	if config.MaxSize < 1 && config.MaxPage < 1 && config.MaxOffset < 1 && !config.UnlimitedDisabled {
+		return nil
+	}
+	return &PageLimits{
+		MaxSize:   config.MaxSize,
+		MaxPage:   config.MaxPage,
+		MaxOffset: config.MaxOffset,
+		Unlimited: !config.UnlimitedDisabled && config.MaxSize < 1,
+	}
+}
+
+func parsingQueryString(param *Request, p *pageRequest) {
+	p.Size = param.Size
+	if p.Size == 0 {
+		if p.Config.DefaultSize > 0 {
+			p.Size = p.Config.DefaultSize
+		} else {
+			p.Size = 10
+		}
+	}
+
+	p.Page = param.Page
+	if p.Page < p.Config.PageStart {
+		p.Page = p.Config.PageStart
+	}
+
+	if err := limitPage(p); nil != err {
+		p.Error = err
+		return
+	}
+
+	p.Sorts = append(p.Sorts, parseSorts(param.Sort, param.Order)...)
+	if len(p.Sorts) < 1 && p.Config.DefaultSort != "" {
+		p.Sorts = parseSorts(p.Config.DefaultSort, "")
+	}
+
+	if len(param.Fields) > 0 {
+		re := regexp.MustCompile(`[^A-z0-9_\.,]+`)
+		for _, field := range param.Fields {
+			fieldName := re.ReplaceAllString(field, "")
+			if fieldName != "" {
+				p.Fields = append(p.Fields, fieldName)
+			}
+		}
+	}
+
+	if param.Timezone != "" {
+		if _, err := time.LoadLocation(param.Timezone); nil != err {
+			p.Error = &ValidationError{Column: "tz", Value: param.Timezone, Message: err.Error()}
+			return
+		}
+		p.Config.Timezone = param.Timezone
+	}
+
+	if len(param.Include) > 0 {
+		re := regexp.MustCompile(`[^A-z0-9_\.]+`)
+		for _, include := range param.Include {
+			name := re.ReplaceAllString(include, "")
+			if name != "" && !contains(p.Includes, name) {
+				p.Includes = append(p.Includes, name)
+			}
+		}
+	}
+
+	// declared preloads are loaded only if the client includes them
+	for name, options := range p.Config.Preloads {
+		if !contains(p.Includes, name) {
+			continue
+		}
+		preload := pagePreload{
+			Size:  options.Size,
+			Sorts: parseSorts(options.Sort, ""),
+		}
+		if preload.Size < 1 {
+			preload.Size = 10
+		}
+		if req, ok := param.Preloads[name]; ok {
+			if req.Size > 0 && req.Size < preload.Size {
+				preload.Size = req.Size
+			}
+			if req.Sort != "" {
+				preload.Sorts = parseSorts(req.Sort, "")
+			}
+		}
+		if nil == p.Preloads {
+			p.Preloads = map[string]pagePreload{}
+		}
+		p.Preloads[name] = preload
+	}
+
+	p.TotalToken = param.TotalToken
+
+	createFilters(param.Filters, p)
+	if maxLength := p.Config.MaxFilterLikeLength; maxLength > 0 && len(param.Search) > maxLength && nil == p.Error {
+		p.Error = &LimitError{Param: "q length", Value: int64(len(param.Search)), Limit: int64(maxLength)}
+		return
+	}
+	// every search term is compared with every search column
+	conditions := len(strings.Fields(param.Search)) * len(p.Config.SearchColumns)
+	if maxConditions := p.Config.MaxFilterConditions; maxConditions > 0 && conditions > maxConditions && nil == p.Error {
+		p.Error = &LimitError{Param: "q conditions", Value: int64(conditions), Limit: int64(maxConditions)}
+		return
+	}
+	p.Search = searchToFilter(param.Search, p.Config)
+}
+
+// joinValues joins values of repeated parameters with comma
+func joinValues(getValues func(string) []string, keys ...string) string {
+	values := []string{}
+	for _, key := range keys {
+		for _, value := range getValues(key) {
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+
+	return strings.Join(values, ",")
+}
+
+// parseSorts parses comma separated sort columns
+func parseSorts(sort string, order string) []sortOrder {
+	sorts := []sortOrder{}
+	if sort == "" {
+		return sorts
+	}
+
+	orders := strings.Split(order, ",")
+	for i, col := range strings.Split(sort, ",") {
+		col = strings.TrimSpace(col)
+		if col == "" {
+			continue
+		}
+
+		so := sortOrder{
+			Column:    col,
+			Direction: "ASC",
+		}
+		direction := ""
+		if len(orders) == 1 {
+			direction = orders[0]
+		} else if i < len(orders) {
+			direction = orders[i]
+		}
+		if strings.ToUpper(strings.TrimSpace(direction)) == "DESC" {
+			so.Direction = "DESC"
+		}
+
+		if string(col[0]) == "-" {
+			so.Column = string(col[1:])
+			so.Direction = "DESC"
+		}
+
+		if slices := strings.Fields(so.Column); len(slices) == 2 {
+			switch strings.ToUpper(slices[1]) {
+			case "ASC", "DESC":
+				so.Column = slices[0]
+				so.Direction = strings.ToUpper(slices[1])
+			}
+		}
+
+		if i := strings.Index(so.Column, ":"); i > 0 {
+			for _, modifier := range strings.Split(so.Column[i+1:], ":") {
+				switch strings.ToLower(modifier) {
+				case "asc", "desc":
+					so.Direction = strings.ToUpper(modifier)
+				case "ci":
+					so.CaseInsensitive = true
+				case "nullsfirst":
+					so.Nulls = "FIRST"
+				case "nullslast":
+					so.Nulls = "LAST"
+				}
+			}
+			so.Column = so.Column[:i]
+		}
+
+		sorts = append(sorts, so)
+	}
+
+	return sorts
 }
 
-func parsingQueryString(param *Request, p *pageRequest) {
-	p.Size = param.Size
-	if p.Size == 0 {
-		if p.Config.DefaultSize > 0 {
-			p.Size = p.Config.DefaultSize
-		} else {
-			p.Size = 10
+// primaryKeySorts generates primary key sorts as tiebreaker
+// when requested sort columns are not unique
+func primaryKeySorts(p pageRequest) []sortOrder {
+	sorts := []sortOrder{}
+	stmt := p.Config.Statement
+	sch := modelSchema(stmt)
+	if nil == sch || len(sch.PrimaryFields) < 1 {
+		return sorts
+	}
+	if _, ok := stmt.Clauses["GROUP BY"]; ok {
+		return sorts
+	}
+	if _, ok := stmt.Clauses["SELECT"]; ok {
+		return sorts
+	}
+
+	sorted := []string{}
+	for _, so := range p.Sorts {
+		if strings.Contains(so.Column, ".") || strings.Contains(so.Column, "->") {
+			continue
+		}
+		if field := sch.LookUpField(so.Column); nil != field {
+			if field.Unique {
+				return sorts
+			}
+			sorted = append(sorted, field.DBName)
 		}
 	}
 
-	p.Page = param.Page
-	if p.Page < p.Config.PageStart {
-		p.Page = p.Config.PageStart
+	for _, field := range sch.PrimaryFields {
+		if contains(sorted, field.DBName) {
+			continue
+		}
+		if !selectsColumn(stmt.Selects, field.DBName) {
+			return []sortOrder{}
+		}
+		sorts = append(sorts, sortOrder{
+			Column:    columnName(field.DBName, p.Config),
+			Direction: "ASC",
+		})
 	}
 
-	if param.Sort != "" {
-		sorts := strings.Split(param.Sort, ",")
-		for _, col := range sorts {
-			if col == "" {
-				continue
-			}
+	return sorts
+}
 
-			so := sortOrder{
-				Column:    col,
-				Direction: "ASC",
-			}
-			if strings.ToUpper(param.Order) == "DESC" {
-				so.Direction = "DESC"
+// selectsColumn reports whether selected columns include the column
+func selectsColumn(selects []string, column string) bool {
+	if len(selects) < 1 {
+		return true
+	}
+	for _, sel := range selects {
+		for _, name := range strings.Split(sel, ",") {
+			name = strings.Trim(strings.TrimSpace(name), "`\"")
+			if name == "*" || name == column || strings.HasSuffix(name, ".*") || strings.HasSuffix(name, "."+column) {
+				return true
 			}
+		}
+	}
 
-			if string(col[0]) == "-" {
-				so.Column = string(col[1:])
-				so.Direction = "DESC"
-			}
+	return false
+}
+
+var sortExpressionPattern = regexp.MustCompile(`\{([A-Za-z0-9_\.]+)\}`)
 
-			p.Sorts = append(p.Sorts, so)
+// sortExpression generates named sort expression, column in braces is quoted
+func sortExpression(expression string, config Config) string {
+	return sortExpressionPattern.ReplaceAllStringFunc(expression, func(column string) string {
+		return columnName(column[1:len(column)-1], config)
+	})
+}
+
+// orderExpression generates order by expression of resolved sort column,
+// null placement is emulated with CASE expression when NULLS FIRST / LAST is not supported
+func orderExpression(so sortOrder, config Config) string {
+	column := so.Column
+	if so.CaseInsensitive {
+		if collation := config.SortCollations[dialectName(config)]; collation != "" {
+			column = column + " COLLATE " + collation
+		} else {
+			column = "LOWER(" + column + ")"
 		}
 	}
+	expression := column + " " + so.Direction
+	if so.Nulls == "" {
+		return expression
+	}
 
-	if len(param.Fields) > 0 {
-		re := regexp.MustCompile(`[^A-z0-9_\.,]+`)
-		for _, field := range param.Fields {
-			fieldName := re.ReplaceAllString(field, "")
-			if fieldName != "" {
-				p.Fields = append(p.Fields, fieldName)
-			}
-		}
+	switch dialectName(config) {
+	case "postgres", "sqlite":
+		return expression + " NULLS " + so.Nulls
 	}
 
-	createFilters(param.Filters, p)
+	nullOrder := "1 ELSE 0"
+	if so.Nulls == "FIRST" {
+		nullOrder = "0 ELSE 1"
+	}
+
+	return "CASE WHEN " + so.Column + " IS NULL THEN " + nullOrder + " END, " + expression
 }
 
 func generateParams(param *Request, config Config, getValue func(string) string) {
@@ -515,8 +1968,60 @@ func generateParams(param *Request, config Config, getValue func(string) string)
 	param.Page, _ = strconv.ParseInt(findValue(config.PageParams, "page"), 10, 64)
 	param.Size, _ = strconv.ParseInt(findValue(config.SizeParams, "size"), 10, 64)
 	param.Order = findValue(config.OrderParams, "order")
+	if param.Sort == "" {
+		param.Sort = getValue("sort[]")
+	}
+	if param.Order == "" {
+		param.Order = getValue("order[]")
+	}
 	param.Filters = findValue(config.FilterParams, "filters")
 	param.Fields = strings.Split(findValue(config.FieldsParams, "fields"), ",")
+	param.Search = findValue(config.SearchParams, "q")
+	param.Timezone = findValue(config.TimezoneParams, "tz")
+	param.Include = strings.Split(findValue(config.IncludeParams, "include"), ",")
+	param.TotalToken = findValue(config.TotalTokenParams, "total_token")
+	generatePreloadParams(param, config, getValue)
+}
+
+// generatePreloadParams reads size and sort of declared preloads, eg: orders.size and orders.sort
+func generatePreloadParams(param *Request, config Config, getValue func(string) string) {
+	for name := range config.Preloads {
+		size, _ := strconv.ParseInt(getValue(name+".size"), 10, 64)
+		sort := getValue(name + ".sort")
+		if size == 0 && sort == "" {
+			continue
+		}
+		if nil == param.Preloads {
+			param.Preloads = map[string]PreloadRequest{}
+		}
+		param.Preloads[name] = PreloadRequest{Size: size, Sort: sort}
+	}
+}
+
+// searchToFilter converts whitespace separated search terms into filters.
+// Every term must match at least one of the search columns.
+func searchToFilter(search string, config Config) pageFilters {
+	terms := strings.Fields(search)
+	if len(terms) < 1 || len(config.SearchColumns) < 1 {
+		return pageFilters{}
+	}
+
+	arr := []interface{}{}
+	for i, term := range terms {
+		if i > 0 {
+			arr = append(arr, []interface{}{"and"})
+		}
+		columns := []interface{}{}
+		for j, column := range config.SearchColumns {
+			if j > 0 {
+				columns = append(columns, []interface{}{"or"})
+			}
+			columns = append(columns, []interface{}{column, "like", term})
+		}
+		arr = append(arr, columns)
+	}
+
+	return arrayToFilter(arr, config)
 }
 
 func arrayToFilter(arr []interface{}, config Config) pageFilters {
@@ -697,10 +2202,10 @@ func generateWhereCauses(f pageFilters, config Config) ([]string, []interface{})
 		if f.IsOperator {
 			wheres = append(wheres, f.Operator)
 		} else {
-			fname := fieldName(f.Column)
-			if nil != config.Statement {
-				fname = config.Statement.Quote(fname)
+			if relWheres, relParams, ok := relationCondition(f, config); ok {
+				return append(wheres, relWheres...), append(params, relParams...)
 			}
+			fname := columnName(f.Column, config)
 			switch f.Operator {
 			case "IS", "IS NOT":
 				if nil == f.Value {
@@ -713,6 +2218,35 @@ func generateWhereCauses(f pageFilters, config Config) ([]string, []interface{})
 						params = append(params, f.Value)
 					}
 				}
+			case "ON":
+				if values, ok := f.Value.([]interface{}); ok && len(values) == 2 {
+					wheres = append(wheres, "(", fname, ">= ? AND", fname, "< ?", ")")
+					params = append(params, values...)
+				}
+			case "BEFORE":
+				wheres = append(wheres, fname, "<", "?")
+				params = append(params, f.Value)
+			case "AFTER":
+				wheres = append(wheres, fname, ">", "?")
+				params = append(params, f.Value)
+			case "HAS_KEY", "CONTAINS":
+				if f.Operator == "CONTAINS" && isArrayColumn(f.Column, config) {
+					arrayWheres, arrayParams := arrayCondition(f, config)
+					wheres = append(wheres, arrayWheres...)
+					params = append(params, arrayParams...)
+					break
+				}
+				jsonWheres, jsonParams := jsonCondition(f, config)
+				wheres = append(wheres, jsonWheres...)
+				params = append(params, jsonParams...)
+			case "ANY", "ALL", "OVERLAPS":
+				arrayWheres, arrayParams := arrayCondition(f, config)
+				wheres = append(wheres, arrayWheres...)
+				params = append(params, arrayParams...)
+			case "NEAR", "WITHIN_BOX":
+				geoWheres, geoParams := geoCondition(f, config)
+				wheres = append(wheres, geoWheres...)
+				params = append(params, geoParams...)
 			case "BETWEEN":
 				if values, ok := f.Value.([]interface{}); ok && len(values) >= 2 {
 					wheres = append(wheres, "(", fname, f.Operator, "? AND ?", ")")
@@ -749,7 +2283,748 @@ func generateWhereCauses(f pageFilters, config Config) ([]string, []interface{})
 		}
 	}
 
-	return wheres, params
+	return wheres, params
+}
+
+// jsonCondition generates has_key and contains conditions of json column
+func jsonCondition(f pageFilters, config Config) ([]string, []interface{}) {
+	wheres := []string{}
+	params := []interface{}{}
+	column, path := splitJSONPath(f.Column)
+	column = fieldName(column)
+	if nil != config.Statement {
+		column = config.Statement.Quote(column)
+	}
+	dialect := dialectName(config)
+
+	if f.Operator == "HAS_KEY" {
+		key := fmt.Sprintf("%v", f.Value)
+		switch dialect {
+		case "postgres":
+			wheres = append(wheres, fmt.Sprintf("jsonb_exists((%s)::jsonb, ?)", jsonPathExpression(column, path, dialect, false)))
+			params = append(params, key)
+		case "mysql":
+			wheres = append(wheres, fmt.Sprintf("JSON_CONTAINS_PATH(%s, 'one', ?)", column))
+			params = append(params, jsonPath(append(path, key)))
+		default:
+			wheres = append(wheres, fmt.Sprintf("json_type(%s, ?) IS NOT NULL", column))
+			params = append(params, jsonPath(append(path, key)))
+		}
+		return wheres, params
+	}
+
+	candidate, err := json.Marshal(f.Value)
+	if nil != err {
+		return wheres, params
+	}
+	switch dialect {
+	case "postgres":
+		wheres = append(wheres, fmt.Sprintf("(%s)::jsonb @> CAST(? AS jsonb)", jsonPathExpression(column, path, dialect, false)))
+		params = append(params, string(candidate))
+	case "mysql":
+		wheres = append(wheres, fmt.Sprintf("JSON_CONTAINS(%s, ?, ?)", column))
+		params = append(params, string(candidate), jsonPath(path))
+	default:
+		conditions := []string{}
+		switch value := f.Value.(type) {
+		case map[string]interface{}:
+			// sorted keys keep the same sql for cache and etag
+			keys := make([]string, 0, len(value))
+			for key := range value {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				conditions = append(conditions, fmt.Sprintf("json_extract(%s, ?) = ?", column))
+				params = append(params, jsonPath(append(path, key)), value[key])
+			}
+		case []interface{}:
+			for _, v := range value {
+				conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s, ?) WHERE value = ?)", column))
+				params = append(params, jsonPath(path), v)
+			}
+		default:
+			conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s, ?) WHERE value = ?)", column))
+			params = append(params, jsonPath(path), value)
+		}
+		if len(conditions) > 0 {
+			wheres = append(wheres, "(", strings.Join(conditions, " AND "), ")")
+		}
+	}
+
+	return wheres, params
+}
+
+// arrayCondition generates any, all, overlaps and contains conditions
+// of postgres array column, or json array column for other databases
+//
+//gocyclo:ignore
+func arrayCondition(f pageFilters, config Config) ([]string, []interface{}) {
+	wheres := []string{}
+	params := []interface{}{}
+	column := columnName(f.Column, config)
+	dialect := dialectName(config)
+
+	values, isArray := f.Value.([]interface{})
+	if !isArray {
+		values = []interface{}{f.Value}
+	} else if f.Operator == "ANY" {
+		f.Operator = "OVERLAPS"
+	} else if f.Operator == "ALL" {
+		f.Operator = "CONTAINS"
+	}
+	values, _ = valueFixer(values).([]interface{})
+
+	if dialect == "postgres" {
+		switch f.Operator {
+		case "ANY", "ALL":
+			wheres = append(wheres, "?", "=", f.Operator+"("+column+")")
+			params = append(params, valueFixer(f.Value))
+		case "OVERLAPS", "CONTAINS":
+			if len(values) < 1 {
+				if f.Operator == "OVERLAPS" {
+					wheres = append(wheres, "1 = 0")
+				} else {
+					wheres = append(wheres, "1 = 1")
+				}
+				break
+			}
+			array := "ARRAY[" + strings.TrimSuffix(strings.Repeat("?,", len(values)), ",") + "]"
+			if dataType := arrayDataType(f.Column, config); dataType != "" {
+				array = "CAST(" + array + " AS " + dataType + ")"
+			}
+			operator := "&&"
+			if f.Operator == "CONTAINS" {
+				operator = "@>"
+			}
+			wheres = append(wheres, column, operator, array)
+			params = append(params, values...)
+		}
+		return wheres, params
+	}
+
+	switch f.Operator {
+	case "ANY":
+		if dialect == "mysql" {
+			candidate, _ := json.Marshal(valueFixer(f.Value))
+			wheres = append(wheres, fmt.Sprintf("JSON_CONTAINS(%s, ?)", column))
+			params = append(params, string(candidate))
+		} else {
+			wheres = append(wheres, fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE value = ?)", column))
+			params = append(params, valueFixer(f.Value))
+		}
+	case "ALL":
+		if dialect == "mysql" {
+			wheres = append(wheres, fmt.Sprintf("JSON_CONTAINS(JSON_ARRAY(?), %s)", column))
+		} else {
+			wheres = append(wheres, fmt.Sprintf("NOT EXISTS (SELECT 1 FROM json_each(%s) WHERE value <> ?)", column))
+		}
+		params = append(params, valueFixer(f.Value))
+	case "OVERLAPS":
+		if len(values) < 1 {
+			wheres = append(wheres, "1 = 0")
+			break
+		}
+		if dialect == "mysql" {
+			conditions := []string{}
+			for _, v := range values {
+				candidate, _ := json.Marshal(v)
+				conditions = append(conditions, fmt.Sprintf("JSON_CONTAINS(%s, ?)", column))
+				params = append(params, string(candidate))
+			}
+			wheres = append(wheres, "(", strings.Join(conditions, " OR "), ")")
+		} else {
+			wheres = append(wheres, fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE value IN ?)", column))
+			params = append(params, values)
+		}
+	case "CONTAINS":
+		if dialect == "mysql" {
+			candidate, _ := json.Marshal(values)
+			wheres = append(wheres, fmt.Sprintf("JSON_CONTAINS(%s, ?)", column))
+			params = append(params, string(candidate))
+		} else {
+			conditions := []string{}
+			for _, v := range values {
+				conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE value = ?)", column))
+				params = append(params, v)
+			}
+			if len(conditions) < 1 {
+				conditions = append(conditions, "1 = 1")
+			}
+			wheres = append(wheres, "(", strings.Join(conditions, " AND "), ")")
+		}
+	}
+
+	return wheres, params
+}
+
+// preloadPage loads has many relation of every item with limited size using window function,
+// it returns total children of every item
+//
+//gocyclo:ignore
+func preloadPage(db *gorm.DB, items interface{}, name string, preload pagePreload, config Config) ([]int64, error) {
+	rv := reflect.Indirect(reflect.ValueOf(items))
+	if rv.Kind() != reflect.Slice || rv.Len() < 1 {
+		return nil, nil
+	}
+	sch, err := schema.Parse(items, schemaCache, db.NamingStrategy)
+	if nil != err {
+		return nil, err
+	}
+	rel, ok := sch.Relationships.Relations[strcase.ToCamel(name)]
+	if !ok || rel.Type != schema.HasMany || nil == rel.FieldSchema {
+		return nil, fmt.Errorf("%s is not a has many relation", name)
+	}
+	var ref *schema.Reference
+	for _, r := range rel.References {
+		if r.OwnPrimaryKey {
+			ref = r
+		}
+	}
+	if nil == ref {
+		return nil, fmt.Errorf("%s is not a has many relation", name)
+	}
+
+	keys := []interface{}{}
+	parents := map[string][]int{}
+	for i := 0; i < rv.Len(); i++ {
+		key, zero := ref.PrimaryKey.ValueOf(rv.Index(i))
+		if zero {
+			continue
+		}
+		k := fmt.Sprint(key)
+		if _, exists := parents[k]; !exists {
+			keys = append(keys, key)
+		}
+		parents[k] = append(parents[k], i)
+		field := rel.Field.ReflectValueOf(reflect.Indirect(rv.Index(i)))
+		field.Set(reflect.MakeSlice(field.Type(), 0, 0))
+	}
+	if len(keys) < 1 {
+		return make([]int64, rv.Len()), nil
+	}
+
+	child := reflect.New(rel.FieldSchema.ModelType).Interface()
+	scope := func() *gorm.DB {
+		stmt := db.Session(&gorm.Session{NewDB: true}).Model(child)
+		childConfig := config
+		childConfig.Statement = stmt.Statement
+		fk := stmt.Statement.Quote(ref.ForeignKey.DBName)
+		stmt = stmt.Where(fk+" IN ?", keys)
+		if nil != rel.Polymorphic {
+			stmt = stmt.Where(stmt.Statement.Quote(rel.Polymorphic.PolymorphicType.DBName)+" = ?", rel.Polymorphic.Value)
+		}
+		filters := pageFilters{}
+		if f, ok := config.Preloads[name].Filters.([]interface{}); ok {
+			filters = arrayToFilter(f, childConfig)
+		} else if f, ok := config.Preloads[name].Filters.(string); ok {
+			iface := []interface{}{}
+			if e := config.JSONUnmarshal([]byte(f), &iface); nil == e && len(iface) > 0 {
+				filters = arrayToFilter(iface, childConfig)
+			}
+		}
+		if wheres, params := generateWhereCauses(filters, childConfig); len(wheres) > 0 {
+			stmt = stmt.Where(strings.Join(wheres, " "), params...)
+		}
+		return stmt
+	}
+
+	inner := scope()
+	fk := inner.Statement.Quote(ref.ForeignKey.DBName)
+	orders := []string{}
+	childConfig := config
+	childConfig.Statement = inner.Statement
+	for _, so := range preload.Sorts {
+		so.Column = columnName(so.Column, childConfig)
+		orders = append(orders, orderExpression(so, childConfig))
+	}
+	if len(orders) < 1 && nil != rel.FieldSchema.PrioritizedPrimaryField {
+		orders = append(orders, inner.Statement.Quote(rel.FieldSchema.PrioritizedPrimaryField.DBName))
+	} else if len(orders) < 1 {
+		orders = append(orders, fk)
+	}
+	inner = inner.Select(inner.Statement.Quote(rel.FieldSchema.Table) + ".*, ROW_NUMBER() OVER (PARTITION BY " + fk +
+		" ORDER BY " + strings.Join(orders, ", ") + ") AS paginate_row_number")
+
+	children := reflect.New(reflect.SliceOf(rel.FieldSchema.ModelType))
+	err = db.Session(&gorm.Session{NewDB: true}).
+		Unscoped().
+		Table("(?) AS c", inner).
+		Where("paginate_row_number <= ?", preload.Size).
+		Order("paginate_row_number").
+		Find(children.Interface()).Error
+	if nil != err {
+		return nil, err
+	}
+
+	childValues := children.Elem()
+	for i := 0; i < childValues.Len(); i++ {
+		key, _ := ref.ForeignKey.ValueOf(childValues.Index(i))
+		for _, index := range parents[fmt.Sprint(key)] {
+			field := rel.Field.ReflectValueOf(reflect.Indirect(rv.Index(index)))
+			value := childValues.Index(i)
+			if field.Type().Elem().Kind() == reflect.Ptr {
+				value = value.Addr()
+			}
+			field.Set(reflect.Append(field, value))
+		}
+	}
+
+	totals := make([]int64, rv.Len())
+	if !config.Preloads[name].TotalEnabled {
+		return totals, nil
+	}
+	rows, err := scope().Select(fk + ", COUNT(*)").Group(ref.ForeignKey.DBName).Rows()
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key interface{}
+		var total int64
+		if err := rows.Scan(&key, &total); nil != err {
+			return nil, err
+		}
+		if b, ok := key.([]byte); ok {
+			key = string(b)
+		}
+		for _, index := range parents[fmt.Sprint(key)] {
+			totals[index] = total
+		}
+	}
+
+	return totals, rows.Err()
+}
+
+// relationCondition generates EXISTS subquery condition of has many
+// and many to many relation column, eg: addresses.city or addresses:none.city
+func relationCondition(f pageFilters, config Config) ([]string, []interface{}, bool) {
+	wheres := []string{}
+	params := []interface{}{}
+	relation, quantifier, column := splitRelationColumn(f.Column)
+	sch := modelSchema(config.Statement)
+	if relation == "" || nil == sch {
+		return wheres, params, false
+	}
+	rel, ok := sch.Relationships.Relations[strcase.ToCamel(relation)]
+	if !ok || (rel.Type != schema.HasMany && rel.Type != schema.Many2Many) || nil == rel.FieldSchema {
+		return wheres, params, false
+	}
+	field := rel.FieldSchema.LookUpField(column)
+	if nil == field {
+		return wheres, params, false
+	}
+
+	child := f
+	child.Column = field.DBName
+	childWheres, childParams := generateWhereCauses(child, config)
+	if len(childWheres) < 1 {
+		return wheres, params, false
+	}
+	if quantifier == "all" {
+		childWheres = append(append([]string{"NOT", "("}, childWheres...), ")")
+	}
+
+	exists := "EXISTS"
+	if quantifier == "all" || quantifier == "none" {
+		exists = "NOT EXISTS"
+	}
+	subQuery := "SELECT 1 FROM " + relationSubQuery(rel, []string{strings.Join(childWheres, " ")}, config)
+	wheres = append(wheres, exists, "(", subQuery, ")")
+	params = append(params, childParams...)
+
+	return wheres, params, true
+}
+
+var aggregateFunctions = map[string]string{
+	"_count": "COUNT",
+	"_max":   "MAX",
+	"_min":   "MIN",
+	"_sum":   "SUM",
+	"_avg":   "AVG",
+}
+
+// isAggregateColumn reports whether the column is relation aggregate pseudo column,
+// eg: addresses._count or orders.created_at._max
+func isAggregateColumn(column string) bool {
+	slices := strings.Split(column, ".")
+	_, ok := aggregateFunctions[slices[len(slices)-1]]
+
+	return ok && (len(slices) == 2 || len(slices) == 3)
+}
+
+// relationAggregate generates correlated subquery of relation aggregate pseudo column
+func relationAggregate(column string, config Config) (string, bool) {
+	sch := modelSchema(config.Statement)
+	if !isAggregateColumn(column) || nil == sch {
+		return "", false
+	}
+	slices := strings.Split(column, ".")
+	rel, ok := sch.Relationships.Relations[strcase.ToCamel(slices[0])]
+	if !ok || (rel.Type != schema.HasMany && rel.Type != schema.Many2Many) || nil == rel.FieldSchema {
+		return "", false
+	}
+
+	argument := "*"
+	if len(slices) == 3 {
+		field := rel.FieldSchema.LookUpField(slices[1])
+		if nil == field || field.DBName == "" {
+			return "", false
+		}
+		argument = config.Statement.Quote(rel.FieldSchema.Table + "." + field.DBName)
+	} else if slices[1] != "_count" {
+		return "", false
+	}
+
+	aggregate := aggregateFunctions[slices[len(slices)-1]]
+	return "(SELECT " + aggregate + "(" + argument + ") FROM " + relationSubQuery(rel, nil, config) + ")", true
+}
+
+// relationSubQuery returns child table with conditions correlated to the parent row
+func relationSubQuery(rel *schema.Relationship, conditions []string, config Config) string {
+	quote := func(table string, column string) string {
+		return config.Statement.Quote(table + "." + column)
+	}
+	table := rel.FieldSchema.Table
+	correlations := []string{}
+	if rel.Type == schema.Many2Many {
+		joinTable := rel.JoinTable.Table
+		joinConditions := []string{}
+		var childKey, joinKey string
+		for _, ref := range rel.References {
+			if ref.OwnPrimaryKey {
+				joinConditions = append(joinConditions, quote(joinTable, ref.ForeignKey.DBName)+" = "+quote("s", ref.PrimaryKey.DBName))
+			} else if ref.PrimaryValue != "" {
+				joinConditions = append(joinConditions, quote(joinTable, ref.ForeignKey.DBName)+" = "+quoteString(ref.PrimaryValue, dialectName(config)))
+			} else {
+				childKey, joinKey = ref.PrimaryKey.DBName, ref.ForeignKey.DBName
+			}
+		}
+		correlations = append(correlations, quote(table, childKey)+" IN (SELECT "+quote(joinTable, joinKey)+
+			" FROM "+config.Statement.Quote(joinTable)+" WHERE "+strings.Join(joinConditions, " AND ")+")")
+	} else {
+		for _, ref := range rel.References {
+			if ref.OwnPrimaryKey {
+				correlations = append(correlations, quote(table, ref.ForeignKey.DBName)+" = "+quote("s", ref.PrimaryKey.DBName))
+			} else if ref.PrimaryValue != "" {
+				correlations = append(correlations, quote(table, ref.ForeignKey.DBName)+" = "+quoteString(ref.PrimaryValue, dialectName(config)))
+			}
+		}
+	}
+	for _, field := range rel.FieldSchema.Fields {
+		if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) && field.DBName != "" {
+			correlations = append(correlations, quote(table, field.DBName)+" IS NULL")
+		}
+	}
+
+	return config.Statement.Quote(table) + " WHERE " + strings.Join(append(correlations, conditions...), " AND ")
+}
+
+// splitRelationColumn splits relation name, quantifier and column name,
+// eg: addresses:all.city returns addresses, all and city
+func splitRelationColumn(column string) (string, string, string) {
+	slices := strings.Split(column, ".")
+	if len(slices) != 2 {
+		return "", "", column
+	}
+	relation := slices[0]
+	quantifier := "any"
+	if i := strings.Index(relation, ":"); i > 0 {
+		quantifier = strings.ToLower(relation[i+1:])
+		relation = relation[:i]
+	}
+
+	return relation, quantifier, slices[1]
+}
+
+// geoCondition generates near and within_box conditions
+func geoCondition(f pageFilters, config Config) ([]string, []interface{}) {
+	wheres := []string{}
+	params := []interface{}{}
+	geo, ok := geoColumn(config)
+	values, isArray := f.Value.([]interface{})
+	if !ok || !isArray {
+		return wheres, params
+	}
+
+	if f.Operator == "NEAR" && len(values) == 3 {
+		radius, _ := values[2].(float64)
+		if geo.Column != "" {
+			latitude, _ := values[0].(float64)
+			longitude, _ := values[1].(float64)
+			wheres = append(wheres, fmt.Sprintf("ST_DWithin(%s, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?)", quoteColumn(geo.Column, config)))
+			params = append(params, longitude, latitude, radius)
+			return wheres, params
+		}
+		if dialectName(config) == "postgres" || dialectName(config) == "mysql" {
+			wheres = append(wheres, "(", distanceExpression(geo, &f, config), ")", "<=", "?")
+			params = append(params, radius)
+			return wheres, params
+		}
+		// the squared distance in degrees, sqlite doesn't have trigonometric functions
+		degrees := radius / earthRadius / math.Pi * 180
+		wheres = append(wheres, "(", distanceExpression(geo, &f, config), ")", "<=", "?")
+		params = append(params, degrees*degrees)
+		return wheres, params
+	}
+
+	if f.Operator == "WITHIN_BOX" && len(values) == 4 {
+		if geo.Column != "" {
+			wheres = append(wheres, fmt.Sprintf("ST_Intersects((%s)::geometry, ST_MakeEnvelope(?, ?, ?, ?, 4326))", quoteColumn(geo.Column, config)))
+			params = append(params, values[1], values[0], values[3], values[2])
+			return wheres, params
+		}
+		lat := quoteColumn(geo.Latitude, config)
+		lng := quoteColumn(geo.Longitude, config)
+		wheres = append(wheres, "(", lat, "BETWEEN ? AND ?", "AND", lng, "BETWEEN ? AND ?", ")")
+		params = append(params, values[0], values[2], values[1], values[3])
+	}
+
+	return wheres, params
+}
+
+const earthRadius = 6371000.0
+
+// distanceExpression returns distance expression in meters from the near filter point.
+// For sqlite, it returns squared distance in degrees instead.
+func distanceExpression(geo GeoColumn, near *pageFilters, config Config) string {
+	values, _ := near.Value.([]interface{})
+	if len(values) < 2 {
+		return ""
+	}
+	latitude, _ := values[0].(float64)
+	longitude, _ := values[1].(float64)
+	lat0 := strconv.FormatFloat(latitude, 'f', -1, 64)
+	lng0 := strconv.FormatFloat(longitude, 'f', -1, 64)
+
+	if geo.Column != "" {
+		return fmt.Sprintf("ST_Distance(%s, ST_SetSRID(ST_MakePoint(%s, %s), 4326)::geography)", quoteColumn(geo.Column, config), lng0, lat0)
+	}
+
+	lat := quoteColumn(geo.Latitude, config)
+	lng := quoteColumn(geo.Longitude, config)
+	switch dialectName(config) {
+	case "postgres", "mysql":
+		return fmt.Sprintf("%s * 2 * ASIN(SQRT(POWER(SIN(RADIANS(%s - %s) / 2), 2) + COS(RADIANS(%s)) * COS(RADIANS(%s)) * POWER(SIN(RADIANS(%s - %s) / 2), 2)))",
+			strconv.FormatFloat(earthRadius, 'f', -1, 64), lat, lat0, lat0, lat, lng, lng0)
+	}
+
+	cos := strconv.FormatFloat(math.Cos(latitude*math.Pi/180), 'f', -1, 64)
+	return fmt.Sprintf("(%s - %s) * (%s - %s) + (%s - %s) * %s * (%s - %s) * %s", lat, lat0, lat, lat0, lng, lng0, cos, lng, lng0, cos)
+}
+
+// findNear returns the first near filter
+func findNear(f pageFilters) *pageFilters {
+	if subs, ok := f.Value.([]pageFilters); ok && !f.Single {
+		for i := range subs {
+			if near := findNear(subs[i]); nil != near {
+				return near
+			}
+		}
+		return nil
+	}
+	if f.Single && f.Operator == "NEAR" {
+		return &f
+	}
+
+	return nil
+}
+
+// geoColumn returns geospatial columns of the statement table
+func geoColumn(config Config) (GeoColumn, bool) {
+	if len(config.GeoColumns) < 1 || nil == config.Statement {
+		return GeoColumn{}, false
+	}
+	table := config.Statement.Table
+	if sch := modelSchema(config.Statement); table == "" && nil != sch {
+		table = sch.Table
+	}
+	geo, ok := config.GeoColumns[table]
+
+	return geo, ok
+}
+
+// itemDistances calculates haversine distance in meters of every item from the point
+func itemDistances(items interface{}, geo GeoColumn, near *pageFilters, config Config) []float64 {
+	values, _ := near.Value.([]interface{})
+	rv := reflect.Indirect(reflect.ValueOf(items))
+	if len(values) < 2 || geo.Latitude == "" || geo.Longitude == "" || rv.Kind() != reflect.Slice || nil == config.Statement {
+		return nil
+	}
+	sch, err := schema.Parse(items, schemaCache, config.Statement.DB.NamingStrategy)
+	if nil != err {
+		return nil
+	}
+	latField := sch.LookUpField(geo.Latitude)
+	lngField := sch.LookUpField(geo.Longitude)
+	if nil == latField || nil == lngField {
+		return nil
+	}
+
+	lat0, _ := values[0].(float64)
+	lng0, _ := values[1].(float64)
+	distances := make([]float64, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		lat, _ := latField.ValueOf(rv.Index(i))
+		lng, _ := lngField.ValueOf(rv.Index(i))
+		distances[i] = haversine(lat0, lng0, toFloat(lat), toFloat(lng))
+	}
+
+	return distances
+}
+
+func haversine(lat1, lng1, lat2, lng2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Pow(math.Sin(dLng/2), 2)
+
+	return earthRadius * 2 * math.Asin(math.Sqrt(a))
+}
+
+func toFloat(value interface{}) float64 {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	}
+
+	return 0
+}
+
+func quoteColumn(column string, config Config) string {
+	if nil != config.Statement {
+		return config.Statement.Quote(column)
+	}
+
+	return column
+}
+
+// isArrayColumn reports whether the column is a postgres array column
+func isArrayColumn(column string, config Config) bool {
+	if dialectName(config) != "postgres" {
+		return false
+	}
+	if arrayDataType(column, config) != "" {
+		return true
+	}
+	if sch := modelSchema(config.Statement); nil != sch {
+		if field := lookupField(sch, column); nil != field {
+			fieldType := field.FieldType
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			return (fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array) &&
+				fieldType.Elem().Kind() != reflect.Uint8 && !strings.Contains(strings.ToLower(string(field.DataType)), "json")
+		}
+	}
+
+	return false
+}
+
+// arrayDataType returns array data type of the column from gorm tag, eg: text[]
+func arrayDataType(column string, config Config) string {
+	if sch := modelSchema(config.Statement); nil != sch {
+		if field := lookupField(sch, column); nil != field {
+			if dataType := strings.ToLower(string(field.DataType)); strings.HasSuffix(dataType, "[]") {
+				return dataType
+			}
+		}
+	}
+
+	return ""
+}
+
+// columnName returns quoted column name or json path expression
+// of the column, eg: meta->color or meta.$.color
+func columnName(column string, config Config) string {
+	col, path := splitJSONPath(column)
+	name := fieldName(col)
+	if nil != config.Statement {
+		name = config.Statement.Quote(name)
+	}
+	if len(path) < 1 {
+		return name
+	}
+
+	return jsonPathExpression(name, path, dialectName(config), true)
+}
+
+// splitJSONPath splits column and json path segments
+func splitJSONPath(column string) (string, []string) {
+	if i := strings.Index(column, "->"); i > 0 {
+		path := []string{}
+		for _, segment := range strings.Split(column[i+2:], "->") {
+			path = append(path, strings.TrimPrefix(segment, ">"))
+		}
+		return column[:i], path
+	}
+	if i := strings.Index(column, ".$."); i > 0 {
+		return column[:i], strings.Split(column[i+3:], ".")
+	}
+
+	return column, nil
+}
+
+var jsonKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var jsonIndexPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// jsonPath builds escaped json path, eg: $.color or $."first name"[0]
+func jsonPath(path []string) string {
+	result := "$"
+	for _, segment := range path {
+		if jsonIndexPattern.MatchString(segment) {
+			result += "[" + segment + "]"
+		} else if jsonKeyPattern.MatchString(segment) {
+			result += "." + segment
+		} else {
+			segment = strings.ReplaceAll(segment, `\`, `\\`)
+			segment = strings.ReplaceAll(segment, `"`, `\"`)
+			result += `."` + segment + `"`
+		}
+	}
+
+	return result
+}
+
+func jsonPathExpression(column string, path []string, dialect string, asText bool) string {
+	if dialect == "postgres" {
+		expr := column
+		for i, segment := range path {
+			operator := "->"
+			if asText && i == len(path)-1 {
+				operator = "->>"
+			}
+			if !jsonIndexPattern.MatchString(segment) {
+				segment = quoteString(segment, dialect)
+			}
+			expr += operator + segment
+		}
+		return expr
+	}
+
+	return fmt.Sprintf("json_extract(%s, %s)", column, quoteString(jsonPath(path), dialect))
+}
+
+// quoteString quotes sql string literal
+func quoteString(value string, dialect string) string {
+	if dialect == "mysql" {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+	}
+
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func dialectName(config Config) string {
+	if nil != config.Statement && nil != config.Statement.Dialector {
+		return config.Statement.Dialector.Name()
+	}
+
+	return ""
 }
 
 func valueFixer(n interface{}) interface{} {
@@ -774,6 +3049,347 @@ func valueFixer(n interface{}) interface{} {
 	return n
 }
 
+// coerceFilters converts filter values to the go type of the target column
+func coerceFilters(f *pageFilters, sch *schema.Schema, config Config) error {
+	if subs, ok := f.Value.([]pageFilters); ok && !f.Single {
+		for i := range subs {
+			if err := coerceFilters(&subs[i], sch, config); nil != err {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !f.Single || f.IsOperator || f.Column == "" {
+		return nil
+	}
+
+	switch f.Operator {
+	case "NEAR", "WITHIN_BOX":
+		if _, ok := geoColumn(config); !ok {
+			return &ValidationError{Column: f.Column, Value: f.Value, Message: "geospatial filter is not available"}
+		}
+		size := 3
+		if f.Operator == "WITHIN_BOX" {
+			size = 4
+		}
+		values, ok := f.Value.([]interface{})
+		if !ok || len(values) != size {
+			return &ValidationError{Column: f.Column, Value: f.Value, Message: fmt.Sprintf("%d coordinates are required", size)}
+		}
+		coordinates := make([]interface{}, size)
+		for i := range values {
+			v, err := strconv.ParseFloat(fmt.Sprintf("%v", values[i]), 64)
+			if nil != err {
+				return &ValidationError{Column: f.Column, Value: values[i], Message: "invalid coordinate"}
+			}
+			coordinates[i] = v
+		}
+		f.Value = coordinates
+		return nil
+	case "ON", "BEFORE", "AFTER":
+		t, err := parseTime(f.Value, config)
+		if nil != err {
+			return &ValidationError{Column: f.Column, Value: f.Value, Message: err.Error()}
+		}
+		f.Value = storageTime(t, config)
+		if f.Operator == "ON" {
+			start := truncateTime(t, "d")
+			f.Value = []interface{}{storageTime(start, config), storageTime(start.AddDate(0, 0, 1), config)}
+		}
+		return nil
+	}
+
+	var field *schema.Field
+	if nil != sch {
+		field = lookupField(sch, f.Column)
+	}
+	if nil == field {
+		return nil
+	}
+
+	switch f.Operator {
+	case "LIKE", "NOT LIKE", "ILIKE", "NOT ILIKE", "HAS_KEY", "CONTAINS", "ANY", "ALL", "OVERLAPS":
+		return nil
+	case "IS", "IS NOT":
+		if strValue, isStr := f.Value.(string); nil == f.Value || (isStr && strings.ToLower(strValue) == "null") {
+			return nil
+		}
+	}
+
+	if values, ok := f.Value.([]interface{}); ok {
+		coerced := make([]interface{}, len(values))
+		for i := range values {
+			value, err := coerceValue(field, values[i], config)
+			if nil != err {
+				return &ValidationError{Column: f.Column, Value: values[i], Message: err.Error()}
+			}
+			coerced[i] = value
+		}
+		f.Value = coerced
+		return nil
+	}
+
+	value, err := coerceValue(field, f.Value, config)
+	if nil != err {
+		return &ValidationError{Column: f.Column, Value: f.Value, Message: err.Error()}
+	}
+	f.Value = value
+
+	return nil
+}
+
+//gocyclo:ignore
+func coerceValue(field *schema.Field, value interface{}, config Config) (interface{}, error) {
+	if nil == value {
+		return nil, nil
+	}
+
+	fieldType := field.FieldType
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+
+	if fieldType == reflect.TypeOf(time.Time{}) {
+		t, err := parseTime(value, config)
+		if nil != err {
+			return nil, err
+		}
+		return storageTime(t, config), nil
+	}
+
+	if scanner, ok := reflect.New(fieldType).Interface().(sql.Scanner); ok {
+		if err := scanner.Scan(value); nil != err {
+			t, timeErr := parseTime(value, config)
+			if nil != timeErr || nil != scanner.Scan(storageTime(t, config)) {
+				return nil, err
+			}
+		}
+		if valuer, ok := scanner.(driver.Valuer); ok {
+			return valuer.Value()
+		}
+		return value, nil
+	}
+
+	strValue := fmt.Sprintf("%v", value)
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if floatValue, ok := value.(float64); ok {
+			if floatValue != math.Trunc(floatValue) {
+				return nil, fmt.Errorf("%v is not an integer", value)
+			}
+			strValue = strconv.FormatFloat(floatValue, 'f', -1, 64)
+		}
+		v, err := strconv.ParseInt(strValue, 10, fieldType.Bits())
+		if nil != err {
+			return nil, fmt.Errorf("%v is not an integer", value)
+		}
+		return v, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if floatValue, ok := value.(float64); ok {
+			if floatValue != math.Trunc(floatValue) {
+				return nil, fmt.Errorf("%v is not an unsigned integer", value)
+			}
+			strValue = strconv.FormatFloat(floatValue, 'f', -1, 64)
+		}
+		v, err := strconv.ParseUint(strValue, 10, fieldType.Bits())
+		if nil != err {
+			return nil, fmt.Errorf("%v is not an unsigned integer", value)
+		}
+		return v, nil
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(strValue, fieldType.Bits())
+		if nil != err {
+			return nil, fmt.Errorf("%v is not a number", value)
+		}
+		return v, nil
+	case reflect.Bool:
+		v, err := strconv.ParseBool(strValue)
+		if nil != err {
+			return nil, fmt.Errorf("%v is not a boolean", value)
+		}
+		return v, nil
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			return strValue, nil
+		}
+	}
+
+	return value, nil
+}
+
+// parseTime parses absolute date or relative date expression,
+// eg: 2021-01-05, now-7d, now/d, startOfMonth or -P7D
+func parseTime(value interface{}, config Config) (time.Time, error) {
+	strValue, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%v is not a valid date", value)
+	}
+
+	loc, err := config.location()
+	if nil != err {
+		return time.Time{}, err
+	}
+
+	if t, ok := parseRelativeTime(strValue, timeNow().In(loc)); ok {
+		return t, nil
+	}
+
+	layouts := config.DateLayouts
+	if len(layouts) < 1 {
+		layouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"}
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, strValue, loc); nil == err {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%v is not a valid date", value)
+}
+
+var timeNow = time.Now
+
+var dateAliases = map[string]string{
+	"today":        "now/d",
+	"yesterday":    "now-1d/d",
+	"tomorrow":     "now+1d/d",
+	"startofday":   "now/d",
+	"startofweek":  "now/w",
+	"startofmonth": "now/M",
+	"startofyear":  "now/y",
+}
+
+var dateMathPattern = regexp.MustCompile(`^now((?:[+-][0-9]+[smhdwMy]|/[smhdwMy])*)$`)
+var dateMathOperation = regexp.MustCompile(`([+-])([0-9]+)([smhdwMy])|/([smhdwMy])`)
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:([0-9]+)Y)?(?:([0-9]+)M)?(?:([0-9]+)W)?(?:([0-9]+)D)?(?:T(?:([0-9]+)H)?(?:([0-9]+)M)?(?:([0-9]+)S)?)?$`)
+
+// parseRelativeTime resolves date math (now-7d/d), aliases (startOfMonth)
+// and ISO-8601 durations (-P1DT12H) relative to now
+func parseRelativeTime(expr string, now time.Time) (time.Time, bool) {
+	if alias, ok := dateAliases[strings.ToLower(expr)]; ok {
+		expr = alias
+	}
+
+	if match := dateMathPattern.FindStringSubmatch(expr); nil != match {
+		t := now
+		for _, op := range dateMathOperation.FindAllStringSubmatch(match[1], -1) {
+			if op[4] != "" {
+				t = truncateTime(t, op[4])
+				continue
+			}
+			n, _ := strconv.Atoi(op[2])
+			if op[1] == "-" {
+				n = -n
+			}
+			t = addTime(t, n, op[3])
+		}
+		return t, true
+	}
+
+	match := durationPattern.FindStringSubmatch(expr)
+	if nil == match || strings.HasSuffix(expr, "P") || strings.HasSuffix(expr, "T") {
+		return time.Time{}, false
+	}
+	sign := 1
+	if match[1] == "-" {
+		sign = -1
+	}
+	t := now
+	for i, unit := range []string{"y", "M", "w", "d", "h", "m", "s"} {
+		if match[i+2] != "" {
+			n, _ := strconv.Atoi(match[i+2])
+			t = addTime(t, sign*n, unit)
+		}
+	}
+
+	return t, true
+}
+
+func addTime(t time.Time, n int, unit string) time.Time {
+	switch unit {
+	case "s":
+		return t.Add(time.Duration(n) * time.Second)
+	case "m":
+		return t.Add(time.Duration(n) * time.Minute)
+	case "h":
+		return t.Add(time.Duration(n) * time.Hour)
+	case "d":
+		return t.AddDate(0, 0, n)
+	case "w":
+		return t.AddDate(0, 0, n*7)
+	case "M":
+		return t.AddDate(0, n, 0)
+	case "y":
+		return t.AddDate(n, 0, 0)
+	}
+
+	return t
+}
+
+// truncateTime rounds down the time to the start of the unit
+func truncateTime(t time.Time, unit string) time.Time {
+	y, m, d := t.Date()
+	loc := t.Location()
+	switch unit {
+	case "s":
+		return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, loc)
+	case "m":
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, loc)
+	case "h":
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, loc)
+	case "d":
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	case "w":
+		weekday := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-weekday, 0, 0, 0, 0, loc)
+	case "M":
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	case "y":
+		return time.Date(y, 1, 1, 0, 0, 0, 0, loc)
+	}
+
+	return t
+}
+
+var schemaCache = &sync.Map{}
+
+// modelSchema returns the parsed schema of the statement model
+func modelSchema(stmt *gorm.Statement) *schema.Schema {
+	if nil == stmt {
+		return nil
+	}
+	if nil != stmt.Schema {
+		return stmt.Schema
+	}
+	if nil == stmt.Model || nil == stmt.DB {
+		return nil
+	}
+	sch, err := schema.Parse(stmt.Model, schemaCache, stmt.DB.NamingStrategy)
+	if nil != err {
+		return nil
+	}
+
+	return sch
+}
+
+// lookupField finds schema field by column name, eg: name or user.name
+func lookupField(sch *schema.Schema, column string) *schema.Field {
+	slices := strings.Split(column, ".")
+	if len(slices) == 2 {
+		relation, _, _ := splitRelationColumn(column)
+		if rel, ok := sch.Relationships.Relations[strcase.ToCamel(relation)]; ok && nil != rel.FieldSchema {
+			return rel.FieldSchema.LookUpField(slices[1])
+		}
+		return nil
+	}
+	if len(slices) > 2 {
+		return nil
+	}
+
+	return sch.LookUpField(column)
+}
+
 func contains(source []string, value string) bool {
 	found := false
 	for i := range source {
@@ -809,12 +3425,30 @@ func fieldName(field string) string {
 
 // Config for customize pagination result
 type Config struct {
-	Operator             string
-	FieldWrapper         string
-	ValueWrapper         string
-	DefaultSize          int64
-	PageStart            int64
-	LikeAsIlikeDisabled  bool
+	Operator               string
+	FieldWrapper           string
+	ValueWrapper           string
+	DefaultSize            int64
+	MaxSize                int64
+	MaxPage                int64
+	MaxOffset              int64
+	UnlimitedDisabled      bool
+	RejectOverLimitEnabled bool
+	MaxFilterDepth         int
+	MaxFilterConditions    int
+	MaxFilterValues        int
+	MaxFilterLikeLength    int
+	SingleflightEnabled    bool
+	TotalCacheEnabled      bool
+	TotalTokenSecret       string
+	TotalTokenTTL          time.Duration
+	Scopes                 []func(context.Context, *gorm.DB) *gorm.DB `json:"-"`
+	DefaultSort            string
+	SortExpressions        map[string]string
+	SortCollations         map[string]string
+	PageStart              int64
+	LikeAsIlikeDisabled    bool
+	// Deprecated: use SearchColumns instead.
 	SmartSearchEnabled   bool
 	Statement            *gorm.Statement `json:"-"`
 	CustomParamEnabled   bool
@@ -824,13 +3458,66 @@ type Config struct {
 	SizeParams           []string
 	FilterParams         []string
 	FieldsParams         []string
+	SearchParams         []string
+	SearchColumns        []string
 	FieldSelectorEnabled bool
+	DateLayouts          []string
+	Timezone             string
+	StorageTimezone      string
+	TimezoneParams       []string
+	IncludeParams        []string
+	TotalTokenParams     []string
+	GeoColumns           map[string]GeoColumn
+	Preloads             map[string]PreloadOptions
 	CacheAdapter         gocache.AdapterInterface               `json:"-"`
+	CacheCodec           CacheCodec                             `json:"-"`
+	CacheCodecs          []CacheCodec                           `json:"-"`
+	CacheObserver        CacheObserver                          `json:"-"`
 	JSONMarshal          func(v interface{}) ([]byte, error)    `json:"-"`
 	JSONUnmarshal        func(data []byte, v interface{}) error `json:"-"`
 	ErrorEnabled         bool
 }
 
+func (c Config) location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(c.Timezone)
+}
+
+// storageTime converts time resolved in the request timezone into the timezone of stored values,
+// sqlite compares times as text so both must be in the same timezone
+func storageTime(t time.Time, config Config) time.Time {
+	if config.StorageTimezone == "" {
+		return t.UTC()
+	}
+	loc, err := time.LoadLocation(config.StorageTimezone)
+	if nil != err {
+		return t.UTC()
+	}
+
+	return t.In(loc)
+}
+
+// PreloadOptions paginated has many preload
+type PreloadOptions struct {
+	// Size maximum children per item, request can only reduce the size
+	Size         int64
+	Sort         string
+	Filters      interface{}
+	TotalEnabled bool
+}
+
+// GeoColumn geospatial columns of a table
+type GeoColumn struct {
+	Latitude  string
+	Longitude string
+	// Column is PostGIS geography column, ST_DWithin is used if not empty
+	Column          string
+	DistanceEnabled bool
+}
+
 // pageFilters struct
 type pageFilters struct {
 	Column      string
@@ -845,28 +3532,79 @@ type pageFilters struct {
 
 // Page result wrapper
 type Page struct {
-	Items        interface{} `json:"items"`
-	Page         int64       `json:"page"`
-	Size         int64       `json:"size"`
-	MaxPage      int64       `json:"max_page"`
-	TotalPages   int64       `json:"total_pages"`
-	Total        int64       `json:"total"`
-	Last         bool        `json:"last"`
-	First        bool        `json:"first"`
-	Visible      int64       `json:"visible"`
-	Error        bool        `json:"error,omitempty"`
-	ErrorMessage string      `json:"error_message,omitempty"`
-	RawError     error       `json:"-"`
+	Items         interface{}        `json:"items"`
+	Page          int64              `json:"page"`
+	Size          int64              `json:"size"`
+	MaxPage       int64              `json:"max_page"`
+	TotalPages    int64              `json:"total_pages"`
+	Total         int64              `json:"total"`
+	Last          bool               `json:"last"`
+	First         bool               `json:"first"`
+	Visible       int64              `json:"visible"`
+	Error         bool               `json:"error,omitempty"`
+	ErrorMessage  string             `json:"error_message,omitempty"`
+	Distances     []float64          `json:"distances,omitempty"`
+	PreloadTotals map[string][]int64 `json:"preload_totals,omitempty"`
+	Limits        *PageLimits        `json:"limits,omitempty"`
+	CachedAt      *time.Time         `json:"cached_at,omitempty"`
+	CacheHit      bool               `json:"cache_hit,omitempty"`
+	TotalToken    string             `json:"total_token,omitempty"`
+	ETag          string             `json:"-"`
+	LastModified  *time.Time         `json:"-"`
+	NotModified   bool               `json:"-"`
+	RawError      error              `json:"-"`
+}
+
+// PageLimits struct
+type PageLimits struct {
+	MaxSize   int64 `json:"max_size,omitempty"`
+	MaxPage   int64 `json:"max_page,omitempty"`
+	MaxOffset int64 `json:"max_offset,omitempty"`
+	Unlimited bool  `json:"unlimited"`
+}
+
+// LimitError is returned when size, page or offset exceeds the configured limit
+type LimitError struct {
+	Param string
+	Value int64
+	Limit int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s %d exceeds the limit of %d", e.Param, e.Value, e.Limit)
+}
+
+// ValidationError is returned when a filter value can't be converted
+// to the type of its column
+type ValidationError struct {
+	Column  string
+	Value   interface{}
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid value %v for %s: %s", e.Value, e.Column, e.Message)
 }
 
 // Request struct
 type Request struct {
-	Page    int64       `json:"page"`
-	Size    int64       `json:"size"`
-	Sort    string      `json:"sort"`
-	Order   string      `json:"order"`
-	Fields  []string    `json:"fields"`
-	Filters interface{} `json:"filters"`
+	Page       int64                     `json:"page"`
+	Size       int64                     `json:"size"`
+	Sort       string                    `json:"sort"`
+	Order      string                    `json:"order"`
+	Fields     []string                  `json:"fields"`
+	Filters    interface{}               `json:"filters"`
+	Search     string                    `json:"q"`
+	Timezone   string                    `json:"tz"`
+	Preloads   map[string]PreloadRequest `json:"preloads"`
+	Include    []string                  `json:"include"`
+	TotalToken string                    `json:"total_token"`
+}
+
+// PreloadRequest size and sort of declared preload
+type PreloadRequest struct {
+	Size int64  `json:"size"`
+	Sort string `json:"sort"`
 }
 
 // query struct
@@ -881,29 +3619,77 @@ type requestQuery struct {
 
 // pageRequest struct
 type pageRequest struct {
-	Size    int64
-	Page    int64
-	Sorts   []sortOrder
-	Filters pageFilters
-	Config  Config `json:"-"`
-	Fields  []string
+	Size       int64
+	Page       int64
+	Sorts      []sortOrder
+	Filters    pageFilters
+	Search     pageFilters
+	Config     Config `json:"-"`
+	Fields     []string
+	Preloads   map[string]pagePreload
+	Includes   []string
+	TotalToken string `json:"-"`
+	Error      error  `json:"-"`
+}
+
+// pagePreload struct
+type pagePreload struct {
+	Size  int64
+	Sorts []sortOrder
 }
 
 // sortOrder struct
 type sortOrder struct {
-	Column    string
-	Direction string
+	Column          string
+	Direction       string
+	Nulls           string
+	CaseInsensitive bool
+}
+
+// cacheKey struct
+type cacheKey struct {
+	Request  pageRequest
+	SQL      string
+	Vars     []interface{}
+	Type     string
+	Parts    []string
+	Preloads []string
+}
+
+// statementPreloads returns sorted preload names with their args of the statement
+func statementPreloads(stmt *gorm.Statement) []string {
+	preloads := []string{}
+	for name, args := range stmt.Preloads {
+		preloads = append(preloads, fmt.Sprintf("%s %v", name, args))
+	}
+	sort.Strings(preloads)
+
+	return preloads
 }
 
-func createCacheKey(cachePrefix string, pr pageRequest) string {
+func createCacheKey(cachePrefix string, ck cacheKey) string {
 	key := ""
-	if bte, err := pr.Config.JSONMarshal(pr); nil == err && cachePrefix != "" {
+	if bte, err := ck.Request.Config.JSONMarshal(ck); nil == err && cachePrefix != "" {
 		key = fmt.Sprintf("%s%x", cachePrefix, md5.Sum(bte))
 	}
 
 	return key
 }
 
+// resultType returns type name of result including package path
+func resultType(res interface{}) string {
+	t := reflect.TypeOf(res)
+	if nil == t {
+		return ""
+	}
+	elem := t
+	for elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Slice || elem.Kind() == reflect.Array {
+		elem = elem.Elem()
+	}
+
+	return t.String() + " " + elem.PkgPath()
+}
+
 func defaultConfig(c *Config) *Config {
 	if nil == c {
 		return &Config{
@@ -922,3 +3708,173 @@ func defaultConfig(c *Config) *Config {
 
 	return c
 }
+
+// CachePlugin creates gorm plugin to clear cached pages automatically
+// when the tables they depend on are created, updated or deleted.
+// eg: db.Use(paginate.CachePlugin(pg))
+func CachePlugin(pg *Pagination) gorm.Plugin {
+	if nil == pg.cacheTags {
+		pg.cacheTags = &cacheTagIndex{}
+	}
+
+	return &cachePlugin{pagination: pg}
+}
+
+// cachePlugin struct
+type cachePlugin struct {
+	pagination *Pagination
+}
+
+func (c *cachePlugin) Name() string {
+	return "paginate:cache"
+}
+
+func (c *cachePlugin) Initialize(db *gorm.DB) error {
+	name := "paginate:clear_cache"
+	if err := db.Callback().Create().After("gorm:create").Register(name, c.clearCache); nil != err {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").Register(name, c.clearCache); nil != err {
+		return err
+	}
+
+	return db.Callback().Delete().After("gorm:delete").Register(name, c.clearCache)
+}
+
+func (c *cachePlugin) clearCache(db *gorm.DB) {
+	if nil != db.Error || nil == c.pagination.Config || nil == c.pagination.Config.CacheAdapter {
+		return
+	}
+	table := db.Statement.Table
+	if table == "" && nil != db.Statement.Schema {
+		table = db.Statement.Schema.Table
+	}
+	c.pagination.cacheTags.clear(c.pagination.Config.CacheAdapter, table, "*")
+}
+
+// maxCacheTagKeys maximum cached pages indexed per table
+var maxCacheTagKeys = 1000
+
+// cacheTagIndex maintains cache keys per table in the cache adapter,
+// the index is only consistent within a single process
+type cacheTagIndex struct {
+	mutex sync.Mutex
+}
+
+func (c *cacheTagIndex) tagKey(table string) string {
+	return "paginate_tags:" + table
+}
+
+func (c *cacheTagIndex) keys(adapter gocache.AdapterInterface, table string) []string {
+	keys := []string{}
+	if value, err := adapter.Get(c.tagKey(table)); nil == err && value != "" {
+		if err := json.Unmarshal([]byte(value), &keys); nil != err {
+			return []string{}
+		}
+	}
+
+	return keys
+}
+
+func (c *cacheTagIndex) add(adapter gocache.AdapterInterface, key string, tables []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, table := range tables {
+		keys := c.keys(adapter, table)
+		if contains(keys, key) {
+			continue
+		}
+		// expired pages are dropped only when the index is full,
+		// then the oldest pages are cleared
+		live := keys
+		if len(keys) >= maxCacheTagKeys {
+			live = make([]string, 0, len(keys)+1)
+			for _, k := range keys {
+				if adapter.IsValid(k) {
+					live = append(live, k)
+				}
+			}
+		}
+		for len(live) >= maxCacheTagKeys {
+			if err := adapter.Clear(live[0]); nil != err {
+				log.Println(err)
+			}
+			live = live[1:]
+		}
+		if value, err := json.Marshal(append(live, key)); nil == err {
+			if err := adapter.Set(c.tagKey(table), string(value)); nil != err {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+func (c *cacheTagIndex) clear(adapter gocache.AdapterInterface, tables ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, table := range tables {
+		keys := c.keys(adapter, table)
+		if len(keys) < 1 {
+			continue
+		}
+		for _, key := range keys {
+			if err := adapter.Clear(key); nil != err {
+				log.Println(err)
+			}
+		}
+		if err := adapter.Clear(c.tagKey(table)); nil != err {
+			log.Println(err)
+		}
+	}
+}
+
+// flightGroup deduplicates concurrent page loads with the same key
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall struct
+type flightCall struct {
+	wg   sync.WaitGroup
+	page Page
+	data []byte
+	dups int
+}
+
+var pageFlights = &flightGroup{}
+
+// do runs load once for concurrent calls with the same key,
+// the page is marshalled for the duplicate callers
+func (g *flightGroup) do(key string, load func() Page, marshal func(interface{}) ([]byte, error)) (*flightCall, bool) {
+	g.mutex.Lock()
+	if nil == g.calls {
+		g.calls = map[string]*flightCall{}
+	}
+	if call, ok := g.calls[key]; ok {
+		call.dups++
+		g.mutex.Unlock()
+		call.wg.Wait()
+		return call, true
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mutex.Unlock()
+
+	defer call.wg.Done()
+	defer func() {
+		g.mutex.Lock()
+		delete(g.calls, key)
+		dups := call.dups
+		g.mutex.Unlock()
+		if dups > 0 {
+			if data, err := marshal(call.page); nil == err {
+				call.data = data
+			}
+		}
+	}()
+	call.page = load()
+
+	return call, false
+}
@@ -2,17 +2,26 @@ package paginate
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/morkid/gocache"
 	"github.com/valyala/fasthttp"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -694,3 +703,1714 @@ func TestGenerateWhereCauses(t *testing.T) {
 	expect(t, "((((name LIKE ? OR email LIKE ? OR address LIKE ?))) OR (id > ?))", where)
 	expect(t, 4, len(params))
 }
+
+func TestSearch(t *testing.T) {
+	query := fmt.Sprintf("q=%s&filters=%s", url.QueryEscape(" john  doe "), url.QueryEscape(`["id",">",1]`))
+	req := &http.Request{
+		Method: "GET",
+		URL: &url.URL{
+			RawQuery: query,
+		},
+	}
+
+	parsed := parseRequest(req, Config{SearchColumns: []string{"name", "email"}})
+	causes := createCauses(parsed)
+
+	where := causes.WhereString
+	where = strings.ReplaceAll(where, "( ", "(")
+	where = strings.ReplaceAll(where, " )", ")")
+	expect(t, "(id > ?) AND ((name LIKE ? OR email LIKE ?) AND (name LIKE ? OR email LIKE ?))", where)
+	expect(t, 5, len(causes.Params))
+	expect(t, "%john%", causes.Params[1])
+	expect(t, "%doe%", causes.Params[4])
+
+	parsed = parseRequest(req, Config{})
+	causes = createCauses(parsed)
+	expect(t, "id > ?", causes.WhereString)
+}
+
+func TestTypeCoercion(t *testing.T) {
+	type Product struct {
+		gorm.Model
+		Name      string    `json:"name"`
+		Stock     int       `json:"stock"`
+		Price     float64   `json:"price"`
+		Active    bool      `json:"active"`
+		ReleaseAt time.Time `json:"release_at"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Product{})
+	db.Create(&[]Product{
+		{Name: "Pen", Stock: 10, Price: 1.5, Active: true, ReleaseAt: time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{Name: "Book", Stock: 0, Price: 12, Active: false, ReleaseAt: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+	})
+
+	stmt := db.Model(&Product{})
+	stmt.Statement.Parse(&Product{})
+	config := Config{Statement: stmt.Statement}
+	parsed := parseRequest(&Request{
+		Filters: []interface{}{
+			[]interface{}{"stock", ">", "5"},
+			[]interface{}{"and"},
+			[]interface{}{"active", "true"},
+			[]interface{}{"and"},
+			[]interface{}{"id", "in", []interface{}{1.0, "2"}},
+		},
+	}, config)
+	expectNil(t, parsed.Error)
+	filters := parsed.Filters.Value.([]pageFilters)
+	expect(t, int64(5), filters[0].Value)
+	expect(t, true, filters[2].Value)
+	ids := filters[4].Value.([]interface{})
+	expect(t, uint64(1), ids[0])
+	expect(t, uint64(2), ids[1])
+
+	parsed = parseRequest(&Request{
+		Filters: []interface{}{"stock", ">", "five"},
+	}, config)
+	validationErr, ok := parsed.Error.(*ValidationError)
+	expectTrue(t, ok, "Invalid error type")
+	if ok {
+		expect(t, "stock", validationErr.Column)
+	}
+
+	response := []Product{}
+	request := &Request{
+		Filters: []interface{}{
+			[]interface{}{"release_at", ">=", "2021-02-01"},
+			[]interface{}{"and"},
+			[]interface{}{"deleted_at", "is", nil},
+		},
+	}
+	page := New(&Config{ErrorEnabled: true}).With(db.Model(&Product{})).Request(request).Response(&response)
+	expectFalse(t, page.Error, page.ErrorMessage)
+	expect(t, int64(1), page.Total)
+
+	request = &Request{Filters: []interface{}{"price", "<", "cheap"}}
+	page = New(&Config{ErrorEnabled: true}).With(db.Model(&Product{})).Request(request).Response(&response)
+	expectTrue(t, page.Error, "Failed to get validation error")
+	_, ok = page.RawError.(*ValidationError)
+	expectTrue(t, ok, "Invalid error type")
+}
+
+func TestRelativeDate(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2021, 3, 17, 15, 30, 0, 0, time.UTC)
+	}
+	defer func() {
+		timeNow = time.Now
+	}()
+
+	jakarta, _ := time.LoadLocation("Asia/Jakarta")
+	expectations := map[string]time.Time{
+		"now":          time.Date(2021, 3, 17, 22, 30, 0, 0, jakarta),
+		"now-7d":       time.Date(2021, 3, 10, 22, 30, 0, 0, jakarta),
+		"now/d":        time.Date(2021, 3, 17, 0, 0, 0, 0, jakarta),
+		"now-1M/M":     time.Date(2021, 2, 1, 0, 0, 0, 0, jakarta),
+		"startOfMonth": time.Date(2021, 3, 1, 0, 0, 0, 0, jakarta),
+		"startOfWeek":  time.Date(2021, 3, 15, 0, 0, 0, 0, jakarta),
+		"yesterday":    time.Date(2021, 3, 16, 0, 0, 0, 0, jakarta),
+		"-P1DT2H":      time.Date(2021, 3, 16, 20, 30, 0, 0, jakarta),
+		"2021-01-05":   time.Date(2021, 1, 5, 0, 0, 0, 0, jakarta),
+	}
+	config := Config{Timezone: "Asia/Jakarta"}
+	for expr, expected := range expectations {
+		result, err := parseTime(expr, config)
+		expectNil(t, err, expr)
+		expectTrue(t, expected.Equal(result), expr, result.String())
+	}
+
+	_, err := parseTime("P", config)
+	expectNotNil(t, err)
+
+	req := &http.Request{
+		Method: "GET",
+		URL: &url.URL{
+			RawQuery: "tz=Asia/Jakarta&filters=" + url.QueryEscape(`[["created_at","on","today"],["and"],["updated_at","after","now-7d"]]`),
+		},
+	}
+	parsed := parseRequest(req, Config{})
+	expectNil(t, parsed.Error)
+	causes := createCauses(parsed)
+	where := strings.ReplaceAll(strings.ReplaceAll(causes.WhereString, "( ", "("), " )", ")")
+	expect(t, "(((created_at >= ? AND created_at < ?) AND updated_at > ?))", where)
+	expect(t, 3, len(causes.Params))
+	expectTrue(t, time.Date(2021, 3, 18, 0, 0, 0, 0, jakarta).Equal(causes.Params[1].(time.Time)))
+
+	req.URL.RawQuery = "tz=Mars/Olympus"
+	parsed = parseRequest(req, Config{})
+	expectNotNil(t, parsed.Error)
+
+	type Event struct {
+		ID uint
+		At time.Time
+	}
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Event{})
+	db.Create(&[]Event{
+		{At: time.Date(2021, 3, 17, 16, 30, 0, 0, time.UTC)},
+		{At: time.Date(2021, 3, 17, 20, 0, 0, 0, time.UTC)},
+		{At: time.Date(2021, 3, 10, 16, 0, 0, 0, time.UTC)},
+	})
+	events := func(filters string) []uint {
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{RawQuery: "sort=id&tz=Asia/Jakarta&filters=" + url.QueryEscape(filters)},
+		}
+		result := []Event{}
+		New().With(db.Model(&Event{})).Request(req).Response(&result)
+		ids := []uint{}
+		for _, event := range result {
+			ids = append(ids, event.ID)
+		}
+		return ids
+	}
+	expect(t, "[2]", fmt.Sprint(events(`["at","on","2021-03-18"]`)))
+	expect(t, "[1]", fmt.Sprint(events(`["at","on","2021-03-17"]`)))
+	expect(t, "[1 2 3]", fmt.Sprint(events(`["at","after","now-7d"]`)))
+	expect(t, "[2]", fmt.Sprint(events(`["at",">=","2021-03-18"]`)))
+}
+
+func TestJSONColumn(t *testing.T) {
+	expect(t, "json_extract(meta, '$.color')", columnName("meta->color", Config{}))
+	expect(t, "json_extract(meta, '$.size[0]')", columnName("meta.$.size.0", Config{}))
+	expect(t, `json_extract(meta, '$."it''s"')`, columnName("meta->it's", Config{}))
+	expect(t, `json_extract(meta, '$."a\"b"')`, columnName(`meta->a"b`, Config{}))
+	expect(t, "meta->'a'->>'color'", jsonPathExpression("meta", []string{"a", "color"}, "postgres", true))
+	expect(t, "meta->'it''s'->0", jsonPathExpression("meta", []string{"it's", "0"}, "postgres", false))
+
+	jsonString := `[
+		["meta->color", "red"],
+		["and"],
+		["meta", "has_key", "size"],
+		["and"],
+		["meta->tags", "contains", "new"]
+	]`
+	var jsonData []interface{}
+	json.Unmarshal([]byte(jsonString), &jsonData)
+	filters := arrayToFilter(jsonData, Config{})
+	wheres, params := generateWhereCauses(filters, Config{})
+
+	where := strings.Join(wheres, " ")
+	where = strings.ReplaceAll(where, "( ", "(")
+	where = strings.ReplaceAll(where, " )", ")")
+	expect(t, "((json_extract(meta, '$.color') = ? AND json_type(meta, ?) IS NOT NULL AND (EXISTS (SELECT 1 FROM json_each(meta, ?) WHERE value = ?))))", where)
+	expect(t, 4, len(params))
+	expect(t, "$.size", params[1])
+	expect(t, "$.tags", params[2])
+
+	for i := 0; i < 10; i++ {
+		_, params = jsonCondition(pageFilters{
+			Column:   "meta",
+			Operator: "CONTAINS",
+			Value:    map[string]interface{}{"d": 4, "b": 2, "a": 1, "c": 3},
+		}, Config{})
+		expect(t, "[$.a 1 $.b 2 $.c 3 $.d 4]", fmt.Sprint(params), "sorted json keys")
+	}
+
+	req := &Request{Sort: "-meta->color"}
+	causes := createCauses(parseRequest(req, Config{}))
+	expect(t, "json_extract(meta, '$.color')", causes.Sorts[0].Column)
+	expect(t, "DESC", causes.Sorts[0].Direction)
+}
+
+func TestArrayColumn(t *testing.T) {
+	jsonString := `[
+		["tags", "any", "go"],
+		["and"],
+		["scores", "all", 5],
+		["and"],
+		["tags", "overlaps", ["go", "rust"]],
+		["and"],
+		["tags", "contains", ["go", "sql"]]
+	]`
+	var jsonData []interface{}
+	json.Unmarshal([]byte(jsonString), &jsonData)
+	filters := arrayToFilter(jsonData, Config{})
+	wheres, params := generateWhereCauses(filters, Config{})
+
+	where := strings.Join(wheres, " ")
+	where = strings.ReplaceAll(where, "( ", "(")
+	where = strings.ReplaceAll(where, " )", ")")
+	expect(t, "((EXISTS (SELECT 1 FROM json_each(tags) WHERE value = ?) AND "+
+		"NOT EXISTS (SELECT 1 FROM json_each(scores) WHERE value <> ?) AND "+
+		"EXISTS (SELECT 1 FROM json_each(tags) WHERE value IN ?) AND "+
+		"(EXISTS (SELECT 1 FROM json_each(tags, ?) WHERE value = ?) AND EXISTS (SELECT 1 FROM json_each(tags, ?) WHERE value = ?))))", where)
+	expect(t, 7, len(params))
+	expect(t, "go", params[0])
+	expect(t, int64(5), params[1])
+	expect(t, 2, len(params[2].([]interface{})))
+
+	wheres, params = arrayCondition(pageFilters{Column: "tags", Operator: "ANY", Value: []interface{}{"go", "rust"}}, Config{})
+	expect(t, "EXISTS (SELECT 1 FROM json_each(tags) WHERE value IN ?)", strings.Join(wheres, " "))
+	expect(t, 1, len(params))
+}
+
+func TestGeoFilter(t *testing.T) {
+	type Store struct {
+		gorm.Model
+		Name string  `json:"name"`
+		Lat  float64 `json:"lat"`
+		Lng  float64 `json:"lng"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Store{})
+	db.Create(&[]Store{
+		{Name: "Monas", Lat: -6.1754, Lng: 106.8272},
+		{Name: "Bundaran HI", Lat: -6.1950, Lng: 106.8230},
+		{Name: "Bogor", Lat: -6.5971, Lng: 106.8060},
+	})
+
+	config := &Config{
+		ErrorEnabled: true,
+		GeoColumns: map[string]GeoColumn{
+			"stores": {Latitude: "lat", Longitude: "lng", DistanceEnabled: true},
+		},
+	}
+	request := &Request{
+		Sort:    "-_distance",
+		Filters: []interface{}{"_location", "near", []interface{}{-6.1751, 106.8650, 6000}},
+	}
+	stores := []Store{}
+	page := New(config).With(db.Model(&Store{})).Request(request).Response(&stores)
+	expectFalse(t, page.Error, page.ErrorMessage)
+	expect(t, int64(2), page.Total)
+	expect(t, 2, len(page.Distances))
+	if len(stores) == 2 && len(page.Distances) == 2 {
+		expect(t, "Bundaran HI", stores[0].Name)
+		expectTrue(t, page.Distances[0] > page.Distances[1], "Invalid distance order")
+		expectTrue(t, math.Abs(page.Distances[1]-4180) < 20, "Invalid distance")
+	}
+
+	request = &Request{
+		Filters: []interface{}{"_location", "within_box", []interface{}{-6.7, 106.7, -6.18, 106.9}},
+	}
+	page = New(config).With(db.Model(&Store{})).Request(request).Response(&stores)
+	expectFalse(t, page.Error, page.ErrorMessage)
+	expect(t, int64(2), page.Total)
+
+	request = &Request{
+		Filters: []interface{}{"_location", "near", []interface{}{-6.1751, "east"}},
+	}
+	page = New(config).With(db.Model(&Store{})).Request(request).Response(&stores)
+	expectTrue(t, page.Error, "Failed to validate coordinates")
+
+	for _, filters := range []interface{}{
+		[]interface{}{"_location", "near", []interface{}{-6.1751, 106.8650, 6000}},
+		[]interface{}{[]interface{}{"name", "Bogor"}, []interface{}{"and"}, []interface{}{"_location", "within_box", []interface{}{-6.7, 106.7, -6.18, 106.9}}},
+	} {
+		page = New(&Config{ErrorEnabled: true}).With(db.Model(&Store{})).Request(&Request{Filters: filters}).Response(&[]Store{})
+		expectTrue(t, page.Error, "geo columns are not configured")
+		if _, ok := page.RawError.(*ValidationError); !ok {
+			t.Errorf("Expected ValidationError, got %v", page.RawError)
+		}
+	}
+}
+
+func TestRelationFilter(t *testing.T) {
+	type Address struct {
+		gorm.Model
+		City   string `json:"city"`
+		UserID uint   `json:"-"`
+	}
+
+	type Language struct {
+		gorm.Model
+		Name string `json:"name"`
+	}
+
+	type User struct {
+		gorm.Model
+		Name      string     `json:"name"`
+		Addresses []Address  `json:"addresses"`
+		Languages []Language `json:"languages" gorm:"many2many:user_languages"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&User{}, &Address{}, &Language{})
+	english := Language{Name: "English"}
+	german := Language{Name: "German"}
+	db.Create(&[]User{
+		{Name: "John", Addresses: []Address{{City: "Berlin"}, {City: "Berlin"}, {City: "Paris"}}, Languages: []Language{english}},
+		{Name: "Jane", Addresses: []Address{{City: "Berlin"}}, Languages: []Language{german}},
+		{Name: "Doe"},
+	})
+
+	expectations := []struct {
+		filters []interface{}
+		total   int64
+	}{
+		{[]interface{}{"addresses.city", "Berlin"}, 2},
+		{[]interface{}{"addresses:all.city", "Berlin"}, 2},
+		{[]interface{}{"addresses:none.city", "Paris"}, 2},
+		{[]interface{}{"languages.name", "like", "germ"}, 1},
+		{[]interface{}{"languages:none.name", "German"}, 2},
+	}
+	for _, e := range expectations {
+		users := []User{}
+		page := New(&Config{ErrorEnabled: true}).With(db.Model(&User{})).Request(&Request{Filters: e.filters}).Response(&users)
+		expectFalse(t, page.Error, page.ErrorMessage)
+		expect(t, e.total, page.Total, fmt.Sprint(e.filters))
+	}
+}
+
+func TestRelationAggregateSort(t *testing.T) {
+	type Order struct {
+		gorm.Model
+		Amount int  `json:"amount"`
+		UserID uint `json:"-"`
+	}
+
+	type User struct {
+		gorm.Model
+		Name   string  `json:"name"`
+		Orders []Order `json:"orders"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&User{}, &Order{})
+	db.Create(&[]User{
+		{Name: "John", Orders: []Order{{Amount: 10}}},
+		{Name: "Jane", Orders: []Order{{Amount: 5}, {Amount: 7}, {Amount: 1}}},
+		{Name: "Doe", Orders: []Order{{Amount: 50}, {Amount: 1}}},
+	})
+
+	expectations := map[string][]string{
+		"-orders._count":      {"Jane", "Doe", "John"},
+		"orders.amount._max":  {"Jane", "John", "Doe"},
+		"-orders.amount._sum": {"Doe", "Jane", "John"},
+		"orders.unknown._max": {"John", "Jane", "Doe"},
+	}
+	for sort, names := range expectations {
+		users := []User{}
+		page := New(&Config{ErrorEnabled: true}).With(db.Model(&User{})).Request(&Request{Sort: sort}).Response(&users)
+		expectFalse(t, page.Error, page.ErrorMessage)
+		expect(t, len(names), len(users), sort)
+		for i := range users {
+			expect(t, names[i], users[i].Name, sort)
+		}
+	}
+}
+
+func TestPaginatedPreload(t *testing.T) {
+	type Order struct {
+		gorm.Model
+		Amount int  `json:"amount"`
+		UserID uint `json:"-"`
+	}
+
+	type User struct {
+		gorm.Model
+		Name   string  `json:"name"`
+		Orders []Order `json:"orders"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&User{}, &Order{})
+	db.Create(&[]User{
+		{Name: "John", Orders: []Order{{Amount: 10}}},
+		{Name: "Jane", Orders: []Order{{Amount: 5}, {Amount: 7}, {Amount: 1}, {Amount: 3}}},
+		{Name: "Doe"},
+	})
+
+	request := &http.Request{
+		Method: "GET",
+		URL: &url.URL{
+			RawQuery: "sort=id&include=orders&orders.size=2&orders.sort=-amount",
+		},
+	}
+	users := []User{}
+	page := New(&Config{ErrorEnabled: true}).
+		With(db.Model(&User{}).Preload("Orders")).
+		Request(request).
+		Preload("orders", PreloadOptions{Size: 3, Sort: "amount", Filters: []interface{}{"amount", ">", 1}, TotalEnabled: true}).
+		Response(&users)
+	expectFalse(t, page.Error, page.ErrorMessage)
+	expect(t, 3, len(users))
+	if len(users) == 3 {
+		expect(t, 1, len(users[0].Orders))
+		expect(t, 2, len(users[1].Orders))
+		expect(t, 0, len(users[2].Orders))
+		if len(users[1].Orders) == 2 {
+			expect(t, 7, users[1].Orders[0].Amount)
+			expect(t, 5, users[1].Orders[1].Amount)
+		}
+	}
+	totals := page.PreloadTotals["orders"]
+	expect(t, 3, len(totals))
+	if len(totals) == 3 {
+		expect(t, int64(1), totals[0])
+		expect(t, int64(3), totals[1])
+		expect(t, int64(0), totals[2])
+	}
+
+	request.URL.RawQuery = "sort=id&include=orders&orders.size=50"
+	users = []User{}
+	New().With(db.Model(&User{})).Request(request).
+		Preload("orders", PreloadOptions{Size: 3}).
+		Response(&users)
+	expect(t, 3, len(users[1].Orders))
+
+	request.URL.RawQuery = "sort=id&orders.size=2"
+	users = []User{}
+	page = New().With(db.Model(&User{})).Request(request).
+		Preload("orders", PreloadOptions{Size: 3, TotalEnabled: true}).
+		Response(&users)
+	expect(t, 0, len(users[1].Orders), "preload without include")
+	expect(t, 0, len(page.PreloadTotals))
+}
+
+func TestInclude(t *testing.T) {
+	type Author struct {
+		gorm.Model
+		Name string `json:"name"`
+	}
+
+	type Comment struct {
+		gorm.Model
+		Body      string `json:"body"`
+		ArticleID uint   `json:"-"`
+	}
+
+	type Article struct {
+		gorm.Model
+		Title    string    `json:"title"`
+		AuthorID uint      `json:"-"`
+		Author   Author    `json:"author"`
+		Comments []Comment `json:"comments"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Author{}, &Article{}, &Comment{})
+	db.Create(&[]Article{
+		{Title: "First", Author: Author{Name: "John"}, Comments: []Comment{{Body: "Nice"}, {Body: "Great"}}},
+		{Title: "Second", Author: Author{Name: "Jane"}},
+	})
+
+	request := &http.Request{
+		Method: "GET",
+		URL: &url.URL{
+			RawQuery: "sort=id&include=author,comments,secrets",
+		},
+	}
+	articles := []Article{}
+	page := New(&Config{ErrorEnabled: true}).
+		With(db.Model(&Article{})).
+		Request(request).
+		Includes([]string{"author", "comments"}).
+		Response(&articles)
+	expectFalse(t, page.Error, page.ErrorMessage)
+	expect(t, 2, len(articles))
+	if len(articles) == 2 {
+		expect(t, "John", articles[0].Author.Name)
+		expect(t, "Jane", articles[1].Author.Name)
+		expect(t, 2, len(articles[0].Comments))
+		expect(t, 0, len(articles[1].Comments))
+	}
+
+	request.URL.RawQuery = `sort=id&include=author&filters=["author.name","Jane"]`
+	articles = []Article{}
+	New().With(db.Model(&Article{})).Request(request).
+		Includes([]string{"author"}).
+		Response(&articles)
+	expect(t, 1, len(articles))
+
+	request.URL.RawQuery = "sort=id&include=author"
+	articles = []Article{}
+	New().With(db.Model(&Article{})).Request(request).
+		Includes([]string{"comments"}).
+		Response(&articles)
+	expect(t, "", articles[0].Author.Name)
+	expect(t, 0, len(articles[0].Comments))
+}
+
+func TestNullsSort(t *testing.T) {
+	type Task struct {
+		gorm.Model
+		Name    string     `json:"name"`
+		DueDate *time.Time `json:"due_date"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	due := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	later := due.AddDate(0, 1, 0)
+	db.AutoMigrate(&Task{})
+	db.Create(&[]Task{
+		{Name: "A", DueDate: &due},
+		{Name: "B"},
+		{Name: "C", DueDate: &later},
+	})
+
+	request := &http.Request{
+		Method: "GET",
+		URL: &url.URL{
+			RawQuery: "sort=-due_date:nullslast",
+		},
+	}
+	tasks := []Task{}
+	page := New(&Config{ErrorEnabled: true}).With(db.Model(&Task{})).Request(request).Response(&tasks)
+	expectFalse(t, page.Error, page.ErrorMessage)
+	expect(t, 3, len(tasks))
+	if len(tasks) == 3 {
+		expect(t, "C", tasks[0].Name)
+		expect(t, "A", tasks[1].Name)
+		expect(t, "B", tasks[2].Name)
+	}
+
+	request.URL.RawQuery = "sort=due_date:nullsfirst"
+	tasks = []Task{}
+	New().With(db.Model(&Task{})).Request(request).Response(&tasks)
+	expect(t, "B", tasks[0].Name)
+	expect(t, "A", tasks[1].Name)
+
+	sorts := parseSorts("-due_date:nullslast,name:nullsfirst", "")
+	expect(t, 2, len(sorts))
+	expect(t, "due_date", sorts[0].Column)
+	expect(t, "DESC", sorts[0].Direction)
+	expect(t, "LAST", sorts[0].Nulls)
+	expect(t, "CASE WHEN due_date IS NULL THEN 1 ELSE 0 END, due_date DESC", orderExpression(sorts[0], Config{}))
+	expect(t, "CASE WHEN name IS NULL THEN 0 ELSE 1 END, name ASC", orderExpression(sorts[1], Config{}))
+}
+
+func TestSortTiebreaker(t *testing.T) {
+	type Ticket struct {
+		gorm.Model
+		Status string `json:"status"`
+		Code   string `json:"code" gorm:"unique"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Ticket{})
+	db.Create(&[]Ticket{
+		{Status: "open", Code: "e"},
+		{Status: "closed", Code: "d"},
+		{Status: "open", Code: "c"},
+		{Status: "closed", Code: "b"},
+		{Status: "open", Code: "a"},
+	})
+
+	pr := parseRequest(&http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "sort=status"},
+	}, Config{Statement: db.Model(&Ticket{}).Statement})
+	causes := createCauses(pr)
+	expect(t, 2, len(causes.Sorts))
+	expect(t, "`id`", causes.Sorts[1].Column)
+
+	pr = parseRequest(&http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "sort=code"},
+	}, Config{Statement: db.Model(&Ticket{}).Statement})
+	expect(t, 1, len(createCauses(pr).Sorts))
+
+	ids := []uint{}
+	for i := 0; i < 3; i++ {
+		tickets := []Ticket{}
+		request := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{RawQuery: fmt.Sprintf("sort=status&size=2&page=%d", i)},
+		}
+		New().With(db.Model(&Ticket{})).Request(request).Response(&tickets)
+		for _, ticket := range tickets {
+			ids = append(ids, ticket.ID)
+		}
+	}
+	expect(t, "[2 4 1 3 5]", fmt.Sprint(ids))
+
+	tickets := []Ticket{}
+	request := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{},
+	}
+	New(&Config{DefaultSort: "-code"}).With(db.Model(&Ticket{})).Request(request).Response(&tickets)
+	expect(t, 5, len(tickets))
+	expect(t, "e", tickets[0].Code)
+}
+
+func TestSortOrderList(t *testing.T) {
+	sorts := parseSorts("a,b,c", "desc,asc")
+	expect(t, 3, len(sorts))
+	expect(t, "DESC", sorts[0].Direction)
+	expect(t, "ASC", sorts[1].Direction)
+	expect(t, "ASC", sorts[2].Direction)
+
+	sorts = parseSorts("a,b", "desc")
+	expect(t, "DESC", sorts[0].Direction)
+	expect(t, "DESC", sorts[1].Direction)
+
+	sorts = parseSorts("a:desc,b asc,-c,d:desc:nullslast", "asc")
+	expect(t, 4, len(sorts))
+	expect(t, "a", sorts[0].Column)
+	expect(t, "DESC", sorts[0].Direction)
+	expect(t, "b", sorts[1].Column)
+	expect(t, "ASC", sorts[1].Direction)
+	expect(t, "c", sorts[2].Column)
+	expect(t, "DESC", sorts[2].Direction)
+	expect(t, "d", sorts[3].Column)
+	expect(t, "DESC", sorts[3].Direction)
+	expect(t, "LAST", sorts[3].Nulls)
+
+	pr := parseRequest(&http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "sort[]=name&sort[]=age&order[]=desc&order[]=asc"},
+	}, Config{})
+	expect(t, 2, len(pr.Sorts))
+	expect(t, "name", pr.Sorts[0].Column)
+	expect(t, "DESC", pr.Sorts[0].Direction)
+	expect(t, "age", pr.Sorts[1].Column)
+	expect(t, "ASC", pr.Sorts[1].Direction)
+
+	fastRequest := &fasthttp.Request{}
+	fastRequest.Header.SetMethod("GET")
+	fastRequest.SetRequestURI("/?sort=name+desc&sort=age&order=asc")
+	pr = parseRequest(fastRequest, Config{})
+	expect(t, 2, len(pr.Sorts))
+	expect(t, "name", pr.Sorts[0].Column)
+	expect(t, "DESC", pr.Sorts[0].Direction)
+	expect(t, "ASC", pr.Sorts[1].Direction)
+}
+
+func TestSortExpression(t *testing.T) {
+	type Post struct {
+		gorm.Model
+		Title    string `json:"title"`
+		Likes    int    `json:"likes"`
+		Comments int    `json:"comments"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Post{})
+	db.Create(&[]Post{
+		{Title: "banana", Likes: 1, Comments: 10},
+		{Title: "Apple", Likes: 5, Comments: 0},
+		{Title: "cherry", Likes: 3, Comments: 1},
+	})
+
+	config := &Config{
+		ErrorEnabled: true,
+		SortExpressions: map[string]string{
+			"popularity": "{likes} * 2 + {comments}",
+		},
+	}
+	request := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "sort=-popularity"},
+	}
+	posts := []Post{}
+	page := New(config).With(db.Model(&Post{})).Request(request).Response(&posts)
+	expectFalse(t, page.Error, page.ErrorMessage)
+	expect(t, 3, len(posts))
+	expect(t, "banana", posts[0].Title)
+	expect(t, "Apple", posts[1].Title)
+
+	request.URL.RawQuery = "sort=title:ci"
+	posts = []Post{}
+	New(config).With(db.Model(&Post{})).Request(request).Response(&posts)
+	expect(t, "Apple", posts[0].Title)
+	expect(t, "banana", posts[1].Title)
+
+	config.SortCollations = map[string]string{"sqlite": "NOCASE"}
+	posts = []Post{}
+	New(config).With(db.Model(&Post{})).Request(request).Response(&posts)
+	expect(t, "Apple", posts[0].Title)
+	expect(t, "cherry", posts[2].Title)
+
+	pr := parseRequest(request, Config{Statement: db.Model(&Post{}).Statement, SortCollations: config.SortCollations})
+	causes := createCauses(pr)
+	expect(t, "`title` COLLATE NOCASE ASC", orderExpression(causes.Sorts[0], pr.Config))
+	expect(t, "LOWER(title) ASC", orderExpression(sortOrder{Column: "title", Direction: "ASC", CaseInsensitive: true}, Config{}))
+	expect(t, "`likes` * 2 + `comments`", sortExpression("{likes} * 2 + {comments}", pr.Config))
+}
+
+func TestPageLimits(t *testing.T) {
+	request := func(query string) *http.Request {
+		return &http.Request{
+			Method: "GET",
+			URL:    &url.URL{RawQuery: query},
+		}
+	}
+
+	pr := parseRequest(request("size=1000&page=2"), Config{MaxSize: 100})
+	expectNil(t, pr.Error)
+	expect(t, int64(100), pr.Size)
+	expect(t, int64(2), pr.Page)
+
+	pr = parseRequest(request("size=-1"), Config{MaxSize: 100})
+	expect(t, int64(100), pr.Size)
+
+	pr = parseRequest(request("size=-1"), Config{})
+	expect(t, int64(-1), pr.Size)
+
+	pr = parseRequest(request("size=-1"), Config{UnlimitedDisabled: true, DefaultSize: 20})
+	expect(t, int64(20), pr.Size)
+
+	pr = parseRequest(request("size=1000"), Config{MaxSize: 100, RejectOverLimitEnabled: true})
+	if err, ok := pr.Error.(*LimitError); ok {
+		expect(t, "size", err.Param)
+		expect(t, int64(1000), err.Value)
+		expect(t, int64(100), err.Limit)
+	} else {
+		t.Errorf("Expected LimitError, got %v", pr.Error)
+	}
+
+	pr = parseRequest(request("page=50"), Config{MaxPage: 10})
+	expect(t, int64(10), pr.Page)
+
+	pr = parseRequest(request("page=50&size=10"), Config{MaxOffset: 100})
+	expect(t, int64(10), pr.Page)
+	expect(t, int64(100), createCauses(pr).Offset)
+
+	pr = parseRequest(request("page=50&size=10"), Config{MaxOffset: 100, RejectOverLimitEnabled: true})
+	if err, ok := pr.Error.(*LimitError); ok {
+		expect(t, "offset", err.Param)
+	} else {
+		t.Errorf("Expected LimitError, got %v", pr.Error)
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	type Item struct {
+		gorm.Model
+		Name string `json:"name"`
+	}
+	db.AutoMigrate(&Item{})
+
+	page := New(&Config{MaxSize: 50, MaxPage: 100, RejectOverLimitEnabled: true, ErrorEnabled: true}).
+		With(db.Model(&Item{})).
+		Request(request("size=500")).
+		Response(&[]Item{})
+	expectTrue(t, page.Error)
+	expect(t, "size 500 exceeds the limit of 50", page.ErrorMessage)
+	expect(t, int64(50), page.Limits.MaxSize)
+	expect(t, int64(100), page.Limits.MaxPage)
+	expectFalse(t, page.Limits.Unlimited)
+
+	page = New().With(db.Model(&Item{})).Request(request("")).Response(&[]Item{})
+	expectTrue(t, nil == page.Limits)
+}
+
+func TestFilterLimits(t *testing.T) {
+	parse := func(filters string, config Config) pageRequest {
+		return parseRequest(&http.Request{
+			Method: "GET",
+			URL:    &url.URL{RawQuery: "filters=" + url.QueryEscape(filters)},
+		}, config)
+	}
+	limitParam := func(err error) string {
+		if limitErr, ok := err.(*LimitError); ok {
+			return limitErr.Param
+		}
+		return ""
+	}
+
+	pr := parse(`[[["a",1],["or"],[["b",2]]],["and"],["c",3]]`, Config{MaxFilterDepth: 4, MaxFilterConditions: 3})
+	expectNil(t, pr.Error)
+
+	pr = parse(`[[["a",1],["or"],[["b",2]]],["and"],["c",3]]`, Config{MaxFilterDepth: 3})
+	expect(t, "filters depth", limitParam(pr.Error))
+
+	pr = parse(`[["a,b",1],["and"],["c",3]]`, Config{MaxFilterConditions: 2})
+	expect(t, "filters conditions", limitParam(pr.Error))
+
+	pr = parse(`["id","in",[1,2,3,4]]`, Config{MaxFilterValues: 3})
+	expect(t, "id values", limitParam(pr.Error))
+
+	pr = parse(`["name","like","abcdef"]`, Config{MaxFilterLikeLength: 5})
+	expect(t, "name length", limitParam(pr.Error))
+
+	pr = parse(`["age","between",[1,2,3]]`, Config{})
+	if err, ok := pr.Error.(*ValidationError); ok {
+		expect(t, "age", err.Column)
+	} else {
+		t.Errorf("Expected ValidationError, got %v", pr.Error)
+	}
+
+	pr = parseRequest(&http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "q=abcdef"},
+	}, Config{MaxFilterLikeLength: 5, SearchColumns: []string{"name"}})
+	expect(t, "q length", limitParam(pr.Error))
+
+	pr = parseRequest(&http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "q=" + url.QueryEscape("a b c")},
+	}, Config{MaxFilterConditions: 5, SearchColumns: []string{"name", "email"}})
+	expect(t, "q conditions", limitParam(pr.Error))
+
+	pr = parseRequest(&http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "q=" + url.QueryEscape("a b")},
+	}, Config{MaxFilterConditions: 5, SearchColumns: []string{"name", "email"}})
+	expectNil(t, pr.Error)
+}
+
+func TestScopes(t *testing.T) {
+	type Document struct {
+		gorm.Model
+		Title    string `json:"title"`
+		TenantID uint   `json:"tenant_id"`
+		Public   bool   `json:"public"`
+	}
+	type tenantKey struct{}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Document{})
+	db.Create(&[]Document{
+		{Title: "a", TenantID: 1, Public: true},
+		{Title: "b", TenantID: 1},
+		{Title: "c", TenantID: 2, Public: true},
+		{Title: "d", TenantID: 2},
+	})
+
+	pg := New(&Config{
+		ErrorEnabled: true,
+		Scopes: []func(context.Context, *gorm.DB) *gorm.DB{
+			func(ctx context.Context, db *gorm.DB) *gorm.DB {
+				return db.Where("tenant_id = ?", ctx.Value(tenantKey{}))
+			},
+		},
+	})
+	request := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: `sort=title&filters=[["tenant_id",2],["or"],["tenant_id",1]]`},
+	}
+	request = request.WithContext(context.WithValue(context.Background(), tenantKey{}, uint(1)))
+
+	stmt := db.Model(&Document{}).Where("public = ?", true).Or("title = ?", "d")
+	documents := []Document{}
+	page := pg.With(stmt).Request(request).Response(&documents)
+	expectFalse(t, page.Error, page.ErrorMessage)
+	expect(t, 1, len(documents))
+	expect(t, "a", documents[0].Title)
+
+	documents = []Document{}
+	page = pg.With(db.Raw("SELECT * FROM documents")).Request(request).Response(&documents)
+	expectFalse(t, page.Error, page.ErrorMessage)
+	expect(t, int64(2), page.Total)
+
+	documents = []Document{}
+	New().With(stmt).Request(request).Response(&documents)
+	expect(t, 3, len(documents))
+
+	scoped := applyScopes(db.Model(&Document{}), context.WithValue(context.Background(), tenantKey{}, uint(2)), pg.Config.Scopes)
+	sql, vars := renderSQL(scoped)
+	expectTrue(t, strings.Contains(sql, "tenant_id = ?"))
+	expect(t, uint(2), vars[0])
+}
+
+func TestCacheKey(t *testing.T) {
+	type Account struct {
+		gorm.Model
+		Name     string `json:"name"`
+		TenantID uint   `json:"tenant_id"`
+	}
+	type AccountName struct {
+		Name string `json:"name"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Account{})
+	db.Create(&[]Account{
+		{Name: "a", TenantID: 1},
+		{Name: "b", TenantID: 2},
+		{Name: "c", TenantID: 2},
+	})
+
+	pg := New(&Config{
+		CacheAdapter: gocache.NewInMemoryCache(gocache.InMemoryCacheConfig{
+			ExpiresIn: time.Hour,
+		}),
+	})
+	request := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "sort=name"},
+	}
+
+	accounts := []Account{}
+	page := pg.With(db.Model(&Account{}).Where("tenant_id = ?", 1)).Request(request).Cache("accounts").Response(&accounts)
+	expect(t, int64(1), page.Total)
+
+	accounts = []Account{}
+	page = pg.With(db.Model(&Account{}).Where("tenant_id = ?", 2)).Request(request).Cache("accounts").Response(&accounts)
+	expect(t, int64(2), page.Total)
+	expect(t, "b", accounts[0].Name)
+
+	db.Create(&Account{Name: "d", TenantID: 2})
+	accounts = []Account{}
+	page = pg.With(db.Model(&Account{}).Where("tenant_id = ?", 2)).Request(request).Cache("accounts").Response(&accounts)
+	expect(t, int64(2), page.Total, "cached page")
+
+	accounts = []Account{}
+	page = pg.With(db.Model(&Account{}).Where("tenant_id = ?", 2)).Request(request).
+		Cache("accounts").
+		CacheKeyParts("user:1", "locale:en").
+		Response(&accounts)
+	expect(t, int64(3), page.Total)
+
+	names := []AccountName{}
+	page = pg.With(db.Model(&Account{}).Where("tenant_id = ?", 2)).Request(request).Cache("accounts").Response(&names)
+	expect(t, 3, len(names))
+
+	pr := parseRequest(request, Config{})
+	key := createCacheKey("accounts", cacheKey{Request: pr, SQL: "SELECT 1", Type: resultType(&accounts)})
+	expect(t, key, createCacheKey("accounts", cacheKey{Request: pr, SQL: "SELECT 1", Type: resultType(&accounts)}))
+	expectTrue(t, key != createCacheKey("accounts", cacheKey{Request: pr, SQL: "SELECT 1", Type: resultType(&names)}))
+	expectTrue(t, key != createCacheKey("accounts", cacheKey{Request: pr, SQL: "SELECT 1", Type: resultType(&accounts), Parts: []string{"user:1"}}))
+	expectTrue(t, key != createCacheKey("accounts", cacheKey{Request: pr, SQL: "SELECT 1", Vars: []interface{}{1}, Type: resultType(&accounts)}))
+
+	preloads := statementPreloads(db.Model(&Account{}).Preload("Tenant").Preload("Orders", "amount > ?", 10).Statement)
+	expect(t, "[Orders [amount > ? 10] Tenant []]", fmt.Sprint(preloads))
+	expectTrue(t, key != createCacheKey("accounts", cacheKey{Request: pr, SQL: "SELECT 1", Type: resultType(&accounts), Preloads: preloads}))
+
+	traces := &traceLogger{}
+	traced := db.Session(&gorm.Session{Logger: traces})
+	sql, vars := renderSQL(traced.Table("(?) AS s", traced.Model(&Account{}).Where("tenant_id = ?", 2)).Where("name = ?", "b"))
+	expect(t, "SELECT * FROM (SELECT * FROM `accounts` WHERE tenant_id = ? AND `accounts`.`deleted_at` IS NULL) AS s WHERE name = ?", sql)
+	expect(t, "[2 b]", fmt.Sprint(vars))
+	expect(t, int32(0), atomic.LoadInt32(&traces.count), "rendered sql is not logged")
+
+	pg = New(&Config{CacheAdapter: gocache.NewInMemoryCache(gocache.InMemoryCacheConfig{ExpiresIn: time.Hour})})
+	pg.With(traced.Model(&Account{})).Request(request).Cache("traced").Response(&[]Account{})
+	expect(t, int32(2), atomic.LoadInt32(&traces.count), "count and select queries")
+}
+
+// traceLogger counts logged queries
+type traceLogger struct {
+	count int32
+}
+
+func (l *traceLogger) LogMode(logger.LogLevel) logger.Interface {
+	return l
+}
+
+func (l *traceLogger) Info(context.Context, string, ...interface{}) {}
+
+func (l *traceLogger) Warn(context.Context, string, ...interface{}) {}
+
+func (l *traceLogger) Error(context.Context, string, ...interface{}) {}
+
+func (l *traceLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	atomic.AddInt32(&l.count, 1)
+}
+
+func TestCachePlugin(t *testing.T) {
+	type Writer struct {
+		gorm.Model
+		Name string `json:"name"`
+	}
+	type Book struct {
+		gorm.Model
+		Title    string `json:"title"`
+		WriterID uint   `json:"-"`
+		Writer   Writer `json:"writer"`
+	}
+	type Tag struct {
+		gorm.Model
+		Name string `json:"name"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Writer{}, &Book{}, &Tag{})
+	db.Create(&Book{Title: "Go", Writer: Writer{Name: "John"}})
+
+	pg := New(&Config{
+		CacheAdapter: gocache.NewInMemoryCache(gocache.InMemoryCacheConfig{
+			ExpiresIn: time.Hour,
+		}),
+	})
+	expectNil(t, db.Use(CachePlugin(pg)))
+
+	request := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "sort=id"},
+	}
+	response := func() []Book {
+		books := []Book{}
+		pg.With(db.Model(&Book{}).Joins("Writer")).Request(request).Cache("books").Response(&books)
+		return books
+	}
+	expect(t, 1, len(response()))
+
+	db.Create(&Book{Title: "Rust", WriterID: 1})
+	expect(t, 2, len(response()))
+
+	db.Create(&Tag{Name: "unrelated"})
+	db.Exec("UPDATE books SET title = ?", "changed")
+	expect(t, "Go", response()[0].Title, "cached page")
+
+	db.Model(&Writer{}).Where("id = ?", 1).Update("name", "Jane")
+	books := response()
+	expect(t, "changed", books[0].Title)
+	expect(t, "Jane", books[0].Writer.Name)
+
+	db.Delete(&Book{}, 2)
+	expect(t, 1, len(response()))
+
+	tables := dependentTables(db.Model(&Book{}).Joins("Writer"), pageRequest{}, nil)
+	expect(t, "[books writers]", fmt.Sprint(tables))
+	expect(t, "[*]", fmt.Sprint(dependentTables(db.Raw("SELECT * FROM books"), pageRequest{}, nil)))
+
+	adapter := gocache.NewInMemoryCache(gocache.InMemoryCacheConfig{
+		ExpiresIn: time.Hour,
+	})
+	maxCacheTagKeys = 3
+	defer func() {
+		maxCacheTagKeys = 1000
+	}()
+	index := &cacheTagIndex{}
+	for _, key := range []string{"page:1", "page:2", "page:3"} {
+		adapter.Set(key, "{}")
+		index.add(adapter, key, []string{"books"})
+	}
+	adapter.Clear("page:1")
+	expect(t, "[page:1 page:2 page:3]", fmt.Sprint(index.keys(adapter, "books")), "keys are pruned only when the index is full")
+
+	adapter.Set("page:4", "{}")
+	index.add(adapter, "page:4", []string{"books"})
+	expect(t, "[page:2 page:3 page:4]", fmt.Sprint(index.keys(adapter, "books")), "expired keys are dropped")
+
+	adapter.Set("page:5", "{}")
+	index.add(adapter, "page:5", []string{"books"})
+	expect(t, "[page:3 page:4 page:5]", fmt.Sprint(index.keys(adapter, "books")))
+	expectFalse(t, adapter.IsValid("page:2"), "the oldest page is cleared")
+}
+
+func TestSingleflight(t *testing.T) {
+	type Product struct {
+		gorm.Model
+		Name string `json:"name"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Product{})
+	db.Create(&[]Product{{Name: "a"}, {Name: "b"}})
+
+	// the leader's first query is blocked until the other callers wait as duplicates
+	release := make(chan struct{})
+	var queries int32
+	db.Callback().Query().Before("gorm:query").Register("test:slow_query", func(db *gorm.DB) {
+		if !db.DryRun && atomic.AddInt32(&queries, 1) == 1 {
+			<-release
+		}
+	})
+	go func() {
+		defer close(release)
+		timeout := time.After(5 * time.Second)
+		for {
+			pageFlights.mutex.Lock()
+			dups := 0
+			for _, call := range pageFlights.calls {
+				dups += call.dups
+			}
+			pageFlights.mutex.Unlock()
+			if dups == 4 {
+				return
+			}
+			select {
+			case <-timeout:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+
+	pg := New(&Config{SingleflightEnabled: true})
+	results := make([][]Product, 5)
+	pages := make([]Page, 5)
+	wg := sync.WaitGroup{}
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			request := &http.Request{
+				Method: "GET",
+				URL:    &url.URL{RawQuery: "sort=name"},
+			}
+			results[i] = []Product{}
+			pages[i] = pg.With(db.Model(&Product{})).Request(request).Response(&results[i])
+		}(i)
+	}
+	wg.Wait()
+
+	expect(t, int32(2), atomic.LoadInt32(&queries), "count and select queries")
+	for i := range results {
+		expect(t, 2, len(results[i]))
+		expect(t, int64(2), pages[i].Total)
+	}
+	results[0][0].Name = "changed"
+	expect(t, "a", results[1][0].Name)
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	type Event struct {
+		gorm.Model
+		Name string `json:"name"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Event{})
+	db.Create(&Event{Name: "a"})
+
+	pg := New(&Config{
+		CacheAdapter: gocache.NewInMemoryCache(gocache.InMemoryCacheConfig{
+			ExpiresIn: time.Hour,
+		}),
+	})
+	response := func(ttl time.Duration, stale time.Duration) ([]Event, Page) {
+		request := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{RawQuery: "sort=id"},
+		}
+		events := []Event{}
+		page := pg.With(db.Model(&Event{})).Request(request).
+			Cache("events").
+			TTL(ttl).
+			StaleFor(stale).
+			Response(&events)
+		return events, page
+	}
+
+	events, page := response(50*time.Millisecond, time.Hour)
+	expect(t, 1, len(events))
+	expectFalse(t, page.CacheHit)
+	expectNotNil(t, page.CachedAt)
+
+	db.Create(&Event{Name: "b"})
+	events, page = response(50*time.Millisecond, time.Hour)
+	expect(t, 1, len(events))
+	expectTrue(t, page.CacheHit)
+
+	time.Sleep(100 * time.Millisecond)
+	events, page = response(50*time.Millisecond, time.Hour)
+	expect(t, 1, len(events), "stale page")
+	expectTrue(t, page.CacheHit)
+
+	waitRefreshes(t)
+	events, page = response(time.Hour, 0)
+	expect(t, 2, len(events), "refreshed page")
+	expectTrue(t, page.CacheHit)
+
+	db.Create(&Event{Name: "c"})
+	time.Sleep(100 * time.Millisecond)
+	events, page = response(50*time.Millisecond, 0)
+	expect(t, 3, len(events), "expired page")
+	expectFalse(t, page.CacheHit)
+
+	var failed int32
+	db.Callback().Query().Before("gorm:query").Register("test:failed_query", func(db *gorm.DB) {
+		if !db.DryRun && atomic.LoadInt32(&failed) == 1 {
+			db.AddError(errors.New("connection lost"))
+		}
+	})
+	db.Create(&Event{Name: "d"})
+	time.Sleep(100 * time.Millisecond)
+	atomic.StoreInt32(&failed, 1)
+	events, page = response(50*time.Millisecond, time.Hour)
+	expect(t, 3, len(events), "stale page")
+	waitRefreshes(t)
+	atomic.StoreInt32(&failed, 0)
+	events, page = response(time.Hour, 0)
+	expectTrue(t, page.CacheHit)
+	expect(t, 3, len(events), "failed refresh keeps the stale page")
+}
+
+// waitRefreshes waits until background refreshes of stale pages are done
+func waitRefreshes(t *testing.T) {
+	timeout := time.After(5 * time.Second)
+	for {
+		done := true
+		pageRefreshes.Range(func(key, value interface{}) bool {
+			done = false
+			return false
+		})
+		if done {
+			return
+		}
+		select {
+		case <-timeout:
+			t.Error("background refresh is not finished")
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestZeroPagination(t *testing.T) {
+	type Note struct {
+		gorm.Model
+		Text string `json:"text"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Note{})
+	db.Create(&[]Note{{Text: "a"}, {Text: "b"}})
+
+	request := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "sort=text"},
+	}
+	notes := []Note{}
+	page := (&Pagination{}).With(db.Model(&Note{})).Request(request).Response(&notes)
+	expectNil(t, page.RawError)
+	expect(t, int64(2), page.Total)
+	expect(t, "a", notes[0].Text)
+}
+
+func TestTotalCache(t *testing.T) {
+	type Order struct {
+		gorm.Model
+		Status string `json:"status"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Order{})
+	db.Create(&[]Order{{Status: "paid"}, {Status: "paid"}, {Status: "paid"}, {Status: "new"}})
+
+	var queries int32
+	db.Callback().Query().Before("gorm:query").Register("test:count_query", func(db *gorm.DB) {
+		if !db.DryRun {
+			atomic.AddInt32(&queries, 1)
+		}
+	})
+	response := func(pg *Pagination, query string) Page {
+		atomic.StoreInt32(&queries, 0)
+		request := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{RawQuery: query},
+		}
+		return pg.With(db.Model(&Order{})).Request(request).Cache("orders").Response(&[]Order{})
+	}
+
+	pg := New(&Config{
+		TotalCacheEnabled: true,
+		CacheAdapter: gocache.NewInMemoryCache(gocache.InMemoryCacheConfig{
+			ExpiresIn: time.Hour,
+		}),
+	})
+	page := response(pg, `size=2&page=0&filters=["status","paid"]`)
+	expect(t, int64(3), page.Total)
+	expect(t, int32(2), atomic.LoadInt32(&queries))
+
+	page = response(pg, `size=2&page=1&sort=-id&filters=["status","paid"]`)
+	expect(t, int64(3), page.Total)
+	expect(t, int32(1), atomic.LoadInt32(&queries), "total from cache")
+
+	page = response(pg, `size=2&page=0&filters=["status","new"]`)
+	expect(t, int64(1), page.Total)
+	expect(t, int32(2), atomic.LoadInt32(&queries))
+
+	pg = New(&Config{TotalTokenSecret: "secret", TotalTokenTTL: time.Hour})
+	page = response(pg, `size=2&page=0&filters=["status","paid"]`)
+	expect(t, int64(3), page.Total)
+	expectTrue(t, page.TotalToken != "")
+	token := page.TotalToken
+
+	page = response(pg, `size=2&page=1&filters=["status","paid"]&total_token=`+token)
+	expect(t, int64(3), page.Total)
+	expect(t, int32(1), atomic.LoadInt32(&queries), "total from token")
+
+	page = response(pg, `size=2&page=1&filters=["status","new"]&total_token=`+token)
+	expect(t, int64(1), page.Total)
+	expect(t, int32(2), atomic.LoadInt32(&queries), "token of other filters")
+
+	page = response(pg, `size=2&page=1&filters=["status","paid"]&total_token=100`+token[1:])
+	expect(t, int64(3), page.Total)
+	expect(t, int32(2), atomic.LoadInt32(&queries), "tampered token")
+
+	key := "orders:total:key"
+	config := Config{TotalTokenSecret: "secret"}
+	signed := func(age time.Duration) string {
+		issuedAt := time.Now().Add(-age).Unix()
+		mac := hmac.New(sha256.New, []byte(config.TotalTokenSecret))
+		mac.Write([]byte(fmt.Sprintf("%s:%d:%d", key, 3, issuedAt)))
+		return fmt.Sprintf("%d.%d.%x", 3, issuedAt, mac.Sum(nil))
+	}
+	_, ok := verifyTotalToken(signed(time.Minute), key, config)
+	expectTrue(t, ok)
+	_, ok = verifyTotalToken(signed(time.Hour), key, config)
+	expectFalse(t, ok, "token expires without TotalTokenTTL")
+	_, ok = verifyTotalToken(signed(-time.Hour), key, config)
+	expectFalse(t, ok, "token issued in the future")
+}
+
+func TestConditionalRequest(t *testing.T) {
+	type Metric struct {
+		gorm.Model
+		Name string `json:"name"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Metric{})
+	db.Create(&[]Metric{{Name: "cpu"}, {Name: "memory"}})
+
+	var queries int32
+	db.Callback().Query().Before("gorm:query").Register("test:count_query", func(db *gorm.DB) {
+		if !db.DryRun {
+			atomic.AddInt32(&queries, 1)
+		}
+	})
+
+	pg := New()
+	request := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "sort=id"},
+		Header: http.Header{},
+	}
+	metrics := []Metric{}
+	page := pg.With(db.Model(&Metric{})).Request(request).Conditional("updated_at").Response(&metrics)
+	expect(t, 2, len(metrics))
+	expect(t, int64(2), page.Total)
+	expect(t, int32(1), atomic.LoadInt32(&queries), "total is counted once with conditional column")
+	expectFalse(t, page.NotModified)
+	expectTrue(t, strings.HasPrefix(page.ETag, `W/"`))
+	expectNotNil(t, page.LastModified)
+
+	recorder := httptest.NewRecorder()
+	expectFalse(t, WriteNetHTTPHeaders(recorder, page))
+	expect(t, page.ETag, recorder.Header().Get("ETag"))
+	lastModified := recorder.Header().Get("Last-Modified")
+	expectTrue(t, lastModified != "")
+
+	request.Header.Set("If-None-Match", page.ETag)
+	metrics = []Metric{}
+	page = pg.With(db.Model(&Metric{})).Request(request).Conditional("updated_at").Response(&metrics)
+	expectTrue(t, page.NotModified)
+	expect(t, 0, len(metrics))
+	expect(t, int64(2), page.Total)
+	recorder = httptest.NewRecorder()
+	expectTrue(t, WriteNetHTTPHeaders(recorder, page))
+	expect(t, http.StatusNotModified, recorder.Code)
+
+	request.Header.Del("If-None-Match")
+	request.Header.Set("If-Modified-Since", lastModified)
+	page = pg.With(db.Model(&Metric{})).Request(request).Conditional("updated_at").Response(&[]Metric{})
+	expectTrue(t, page.NotModified)
+
+	db.Create(&Metric{Name: "disk"})
+	request.Header.Del("If-Modified-Since")
+	request.Header.Set("If-None-Match", page.ETag)
+	metrics = []Metric{}
+	page = pg.With(db.Model(&Metric{})).Request(request).Conditional("updated_at").Response(&metrics)
+	expectFalse(t, page.NotModified)
+	expect(t, 3, len(metrics))
+
+	fastRequest := &fasthttp.Request{}
+	fastRequest.Header.SetMethod("GET")
+	fastRequest.SetRequestURI("/?sort=id")
+	page = pg.With(db.Model(&Metric{})).Request(fastRequest).Conditional("").Response(&[]Metric{})
+	expectTrue(t, strings.HasPrefix(page.ETag, `"`))
+	expectFalse(t, page.NotModified)
+
+	fastRequest.Header.Set("If-None-Match", page.ETag)
+	page = pg.With(db.Model(&Metric{})).Request(fastRequest).Conditional("").Response(&[]Metric{})
+	expectTrue(t, page.NotModified)
+	ctx := &fasthttp.RequestCtx{}
+	expectTrue(t, WriteFastHTTPHeaders(ctx, page))
+	expect(t, fasthttp.StatusNotModified, ctx.Response.StatusCode())
+}
+
+func TestCacheCodec(t *testing.T) {
+	type Product struct {
+		gorm.Model
+		Name  string  `json:"name"`
+		Price float64 `json:"price"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Product{})
+	db.Create(&[]Product{{Name: "a", Price: 1.5}, {Name: "b", Price: 2}, {Name: "c", Price: 3}})
+
+	adapter := gocache.NewInMemoryCache(gocache.InMemoryCacheConfig{
+		ExpiresIn: time.Hour,
+	})
+	response := func(codec CacheCodec, products *[]Product) Page {
+		request := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{RawQuery: "sort=name&size=2"},
+		}
+		pg := New(&Config{CacheAdapter: adapter, CacheCodec: codec})
+		return pg.With(db.Model(&Product{})).Request(request).Cache("products").Response(products)
+	}
+
+	for _, codec := range []CacheCodec{GobCodec{}, GzipCodec{}, ZstdCodec{Codec: GobCodec{}}} {
+		adapter.ClearPrefix("products")
+		products := []Product{}
+		page := response(codec, &products)
+		expectFalse(t, page.CacheHit, codec.Name())
+
+		products = []Product{}
+		page = response(codec, &products)
+		expectTrue(t, page.CacheHit, codec.Name())
+		expect(t, int64(3), page.Total, codec.Name())
+		expect(t, 2, len(products), codec.Name())
+		expect(t, "a", products[0].Name, codec.Name())
+		expect(t, 1.5, products[0].Price, codec.Name())
+		expectNotNil(t, page.CachedAt, codec.Name())
+	}
+
+	adapter.ClearPrefix("products")
+	products := []Product{}
+	response(nil, &products)
+
+	products = []Product{}
+	page := response(ZstdCodec{}, &products)
+	expectTrue(t, page.CacheHit, "plain json entry")
+	expect(t, "a", products[0].Name)
+
+	adapter.ClearPrefix("products")
+	response(ZstdCodec{Codec: GobCodec{}}, &[]Product{})
+
+	products = []Product{}
+	page = response(GzipCodec{}, &products)
+	expectTrue(t, page.CacheHit, "zstd entry read by gzip config")
+	expect(t, "a", products[0].Name)
+
+	products = []Product{}
+	page = response(nil, &products)
+	expectTrue(t, page.CacheHit, "zstd entry read by default config")
+	expect(t, 2, len(products))
+
+	var marshals int32
+	for _, codec := range []CacheCodec{JSONCodec{}, GobCodec{}, GzipCodec{}} {
+		adapter.ClearPrefix("products")
+		atomic.StoreInt32(&marshals, 0)
+		pg := New(&Config{
+			CacheAdapter: adapter,
+			CacheCodec:   codec,
+			JSONMarshal: func(v interface{}) ([]byte, error) {
+				if _, ok := v.(Page); ok {
+					atomic.AddInt32(&marshals, 1)
+				}
+				return json.Marshal(v)
+			},
+		})
+		request := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{RawQuery: "sort=name&size=2"},
+			Header: http.Header{},
+		}
+		pg.With(db.Model(&Product{})).Request(request).Cache("products").Conditional("updated_at").Response(&[]Product{})
+		page = pg.With(db.Model(&Product{})).Request(request).Cache("products").Conditional("updated_at").Response(&[]Product{})
+		expectTrue(t, page.CacheHit, codec.Name())
+		expectTrue(t, page.ETag != "", codec.Name(), "etag of cache hit")
+		expectNotNil(t, page.LastModified, codec.Name())
+		if codec.Name() != "gob" {
+			expectTrue(t, atomic.LoadInt32(&marshals) > 0, codec.Name(), "config json marshal")
+		}
+	}
+}
+
+type testCacheObserver struct {
+	mutex  sync.Mutex
+	events []string
+}
+
+func (o *testCacheObserver) add(event string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.events = append(o.events, event)
+}
+
+func (o *testCacheObserver) OnCacheHit(prefix string, duration time.Duration) {
+	o.add("hit " + prefix)
+}
+
+func (o *testCacheObserver) OnCacheMiss(prefix string, duration time.Duration) {
+	o.add("miss " + prefix)
+}
+
+func (o *testCacheObserver) OnCacheSetError(prefix string, err error, duration time.Duration) {
+	o.add("set_error " + prefix)
+}
+
+func (o *testCacheObserver) OnCacheGetError(prefix string, err error, duration time.Duration) {
+	o.add("get_error " + prefix)
+}
+
+type failingCodec struct {
+	JSONCodec
+}
+
+func (failingCodec) Encode(v interface{}) ([]byte, error) {
+	return nil, errors.New("encode failed")
+}
+
+func TestCacheObserver(t *testing.T) {
+	type Invoice struct {
+		gorm.Model
+		Number string `json:"number"`
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if nil != err {
+		t.Error(err.Error())
+		return
+	}
+	db.AutoMigrate(&Invoice{})
+	db.Create(&[]Invoice{{Number: "001"}, {Number: "002"}})
+
+	adapter := gocache.NewInMemoryCache(gocache.InMemoryCacheConfig{
+		ExpiresIn: time.Hour,
+	})
+	request := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{RawQuery: "sort=number"},
+	}
+	observer := &testCacheObserver{}
+	pg := New(&Config{
+		CacheAdapter:      adapter,
+		CacheObserver:     observer,
+		TotalCacheEnabled: true,
+	})
+	pg.With(db.Model(&Invoice{})).Request(request).Cache("invoices").Response(&[]Invoice{})
+	pg.With(db.Model(&Invoice{})).Request(request).Cache("invoices").Response(&[]Invoice{})
+	expect(t, "[miss invoices miss invoicestotal: hit invoices]", fmt.Sprint(observer.events))
+
+	observer.events = nil
+	adapter.ClearPrefix("invoices")
+	pg = New(&Config{
+		CacheAdapter:  adapter,
+		CacheObserver: observer,
+		CacheCodec:    failingCodec{},
+	})
+	pg.With(db.Model(&Invoice{})).Request(request).Cache("invoices").Response(&[]Invoice{})
+	expect(t, "[miss invoices set_error invoices]", fmt.Sprint(observer.events))
+
+	expvarObserver := NewExpvarObserver("paginate_test_cache")
+	expect(t, expvarObserver.vars, NewExpvarObserver("paginate_test_cache").vars)
+	pg = New(&Config{
+		CacheAdapter:  adapter,
+		CacheObserver: expvarObserver,
+	})
+	adapter.ClearPrefix("invoices")
+	pg.With(db.Model(&Invoice{})).Request(request).Cache("invoices").Response(&[]Invoice{})
+	pg.With(db.Model(&Invoice{})).Request(request).Cache("invoices").Response(&[]Invoice{})
+	pg.With(db.Model(&Invoice{})).Request(request).Cache("invoices").Response(&[]Invoice{})
+	vars := expvarObserver.vars.Get("invoices").(*expvar.Map)
+	expect(t, "1", vars.Get("miss").String())
+	expect(t, "2", vars.Get("hit").String())
+	expectNotNil(t, vars.Get("hit_ns"))
+}